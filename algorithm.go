@@ -0,0 +1,90 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// Algorithm selects the hash function used for both file contents and directory pseudo-files.
+// The zero value is AlgorithmSHA256.
+type Algorithm int
+
+const (
+	// AlgorithmSHA256 is SHA-256, the algorithm used by HashDir.
+	AlgorithmSHA256 Algorithm = iota
+
+	// AlgorithmSHA512 is SHA-512.
+	AlgorithmSHA512
+
+	// AlgorithmSHA3_256 is SHA3-256.
+	AlgorithmSHA3_256
+
+	// AlgorithmBLAKE3 is BLAKE3 with its default 256-bit output. Large files are hashed a batch
+	// of 256KiB at a time, with the subtrees of each batch spread across every processor, so the
+	// time to hash a single big file falls with the number of cores. On one core it is about as
+	// fast as SHA-256 without hardware support, and files no bigger than a batch are always
+	// hashed on one core; where SHA-256 has hardware support, it takes several cores to beat.
+	AlgorithmBLAKE3
+
+	// AlgorithmXXH64 is the 64-bit xxHash function. It is many times faster than SHA-256, but it
+	// is NOT a cryptographic hash: collisions can be constructed deliberately, so it must never
+	// be relied upon to detect tampering. It is meant for spotting accidental changes cheaply,
+	// for instance when computing cache keys in CI.
+	AlgorithmXXH64
+)
+
+var algorithmNames = map[Algorithm]string{
+	AlgorithmSHA256:   "sha256",
+	AlgorithmSHA512:   "sha512",
+	AlgorithmSHA3_256: "sha3-256",
+	AlgorithmBLAKE3:   "blake3",
+	AlgorithmXXH64:    "xxh64",
+}
+
+// ParseAlgorithm returns the algorithm with the given name, as returned by Algorithm.String.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for a, n := range algorithmNames {
+		if n == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %q", name)
+}
+
+// String returns the short lowercase name of the algorithm, such as "sha256".
+func (a Algorithm) String() string {
+	if name, ok := algorithmNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Algorithm(%d)", int(a))
+}
+
+// New returns a new hash.Hash computing this algorithm.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case AlgorithmSHA512:
+		return sha512.New()
+	case AlgorithmSHA3_256:
+		return sha3.New256()
+	case AlgorithmBLAKE3:
+		return newBlake3()
+	case AlgorithmXXH64:
+		return newXXH64()
+	default:
+		return sha256.New()
+	}
+}
+
+// Cryptographic reports whether the algorithm is a cryptographic hash, which can be relied upon
+// to detect deliberate tampering rather than just accidental changes.
+func (a Algorithm) Cryptographic() bool {
+	return a != AlgorithmXXH64
+}
+
+// DigestSize returns the length in bytes of the hashes computed by this algorithm.
+func (a Algorithm) DigestSize() int {
+	return a.New().Size()
+}
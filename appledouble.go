@@ -0,0 +1,200 @@
+package dirhash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// AppleDoubleMode says what to do about the resource forks and Finder information which macOS
+// keeps alongside files. On APFS and HFS+ they are kept by the filesystem itself, but copied
+// anywhere else, such as to FAT, a network share, or an archive, they are written out to an
+// AppleDouble file of their own beside each file, named for the file with "._" in front.
+type AppleDoubleMode int
+
+const (
+	// AppleDoubleKeep, the default, leaves forks kept by the filesystem out of the hash, and
+	// hashes AppleDouble files like any other.
+	AppleDoubleKeep AppleDoubleMode = iota
+
+	// AppleDoubleIgnore leaves every file whose name begins with "._" out of the hash, along
+	// with forks kept by the filesystem, so that a tree hashes the same before and after macOS
+	// copies it somewhere which scatters AppleDouble files through it.
+	AppleDoubleIgnore
+
+	// AppleDoubleMerge records the resource fork and Finder information of every file and
+	// subdirectory, whether the filesystem keeps them or they are found in an AppleDouble file,
+	// which is itself left out of the hash just as under AppleDoubleIgnore. A tree then hashes
+	// the same on APFS as wherever it is copied, and a changed resource fork or Finder label
+	// changes the hash. Forks kept by the filesystem are only read on macOS, and take the place
+	// of any AppleDouble file there too. They are appended to the entry's pseudo-file line
+	// after its name and any other attributes, as in
+	//
+	//     <hash> "Icon\r" finderinfo=<32 bytes in capitalized hexadecimal> resourcefork=<hash>
+	//
+	// where the resource fork is hashed in the same way as the contents of a file. Finder
+	// information which is all zeroes, and a resource fork which is empty, are the same as
+	// none at all, and are left out. An AppleDouble file which can't be read, or isn't one at
+	// all, fails just as the entry it belongs to would if that couldn't be read.
+	AppleDoubleMerge
+)
+
+var appleDoubleModeNames = map[AppleDoubleMode]string{
+	AppleDoubleKeep:   "keep",
+	AppleDoubleIgnore: "ignore",
+	AppleDoubleMerge:  "merge",
+}
+
+// ParseAppleDoubleMode returns the mode with the given name, as returned by
+// AppleDoubleMode.String.
+func ParseAppleDoubleMode(name string) (AppleDoubleMode, error) {
+	for m, n := range appleDoubleModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown AppleDouble mode %q", name)
+}
+
+// String returns the short lowercase name of the mode, such as "merge".
+func (m AppleDoubleMode) String() string {
+	if name, ok := appleDoubleModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("AppleDoubleMode(%d)", int(m))
+}
+
+// appleDoublePrefix begins the name of every AppleDouble file, the rest of which is the name of
+// the file it belongs to.
+const appleDoublePrefix = "._"
+
+// The layout of an AppleDouble file: a header giving the number of entries, followed by the id,
+// offset, and length of each, all big-endian.
+const (
+	appleDoubleMagic      = 0x00051607
+	appleDoubleHeaderSize = 26
+	appleDoubleEntrySize  = 12
+	appleDoubleFork       = 2 // The id of the resource fork
+	appleDoubleFinderInfo = 9 // The id of the Finder information
+	finderInfoSize        = 32
+)
+
+// errNotAppleDouble is the underlying error when a file named as an AppleDouble file isn't one.
+var errNotAppleDouble = errors.New("not an AppleDouble file")
+
+// leaveOutAppleDouble reports whether the entry called name within the directory dir is an
+// AppleDouble file to be left out of the hash, remembering it under AppleDoubleMerge for the
+// entry it belongs to.
+func (w *walker) leaveOutAppleDouble(dir, name string, isDir bool) bool {
+	if w.opts.AppleDouble == AppleDoubleKeep || isDir || !strings.HasPrefix(name, appleDoublePrefix) {
+		return false
+	}
+	if w.opts.AppleDouble == AppleDoubleMerge && len(name) > len(appleDoublePrefix) {
+		w.appleDoubles.Store(w.join(dir, name[len(appleDoublePrefix):]), w.join(dir, name))
+	}
+	return true
+}
+
+// appleAttributes returns the attributes recording the Finder information and resource fork
+// of the entry at path, under AppleDoubleMerge.
+func (w *walker) appleAttributes(path string) (string, error) {
+	var finderInfo []byte
+	var fork io.ReadCloser
+	var err error
+	if w.fsys == nil {
+		if finderInfo, fork, err = nativeForks(path); err != nil {
+			return "", err
+		}
+	}
+	if companion, ok := w.appleDoubles.LoadAndDelete(path); ok && finderInfo == nil && fork == nil {
+		if finderInfo, fork, err = w.readAppleDouble(companion.(string)); err != nil {
+			return "", err
+		}
+	}
+
+	var attrs string
+	if finderInfo != nil && !bytes.Equal(finderInfo, make([]byte, len(finderInfo))) {
+		attrs += fmt.Sprintf(" finderinfo=%X", finderInfo)
+	}
+	if fork != nil {
+		defer fork.Close()
+		hasher := w.newHash()
+		if w.opts.DomainSeparateNodes {
+			hasher.Write([]byte{leafPrefix})
+		}
+		n, err := io.Copy(hasher, fork)
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			attrs += fmt.Sprintf(" resourcefork=%X", hasher.Sum(nil))
+		}
+	}
+	return attrs, nil
+}
+
+// readAppleDouble reads the AppleDouble file at path, returning the Finder information it holds
+// and a reader for the resource fork, either of which is nil if it has none. The file is held
+// open until the reader is closed.
+func (w *walker) readAppleDouble(path string) (finderInfo []byte, fork io.ReadCloser, err error) {
+	file, err := w.open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if fork == nil {
+			file.Close()
+		}
+	}()
+
+	// Entries can be anywhere in the file, so it must be read out of order, or else all at once
+	var contents io.ReaderAt
+	if f, ok := diskFile(file); ok {
+		contents = f
+	} else if at, ok := file.(io.ReaderAt); ok {
+		contents = at
+	} else {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		contents = bytes.NewReader(data)
+	}
+
+	header := make([]byte, appleDoubleHeaderSize)
+	if _, err := contents.ReadAt(header, 0); err != nil || binary.BigEndian.Uint32(header) != appleDoubleMagic {
+		return nil, nil, &os.PathError{Op: "read", Path: path, Err: errNotAppleDouble}
+	}
+	table := make([]byte, int(binary.BigEndian.Uint16(header[24:]))*appleDoubleEntrySize)
+	if _, err := contents.ReadAt(table, appleDoubleHeaderSize); err != nil {
+		return nil, nil, &os.PathError{Op: "read", Path: path, Err: errNotAppleDouble}
+	}
+
+	var forkSection *io.SectionReader
+	for entry := table; len(entry) > 0; entry = entry[appleDoubleEntrySize:] {
+		id := binary.BigEndian.Uint32(entry)
+		offset := int64(binary.BigEndian.Uint32(entry[4:]))
+		length := int64(binary.BigEndian.Uint32(entry[8:]))
+		switch id {
+		case appleDoubleFinderInfo:
+			// Anything after the Finder information proper holds extended attributes
+			finderInfo = make([]byte, min(length, finderInfoSize))
+			if _, err := contents.ReadAt(finderInfo, offset); err != nil {
+				return nil, nil, &os.PathError{Op: "read", Path: path, Err: errNotAppleDouble}
+			}
+		case appleDoubleFork:
+			forkSection = io.NewSectionReader(contents, offset, length)
+		}
+	}
+	if forkSection == nil {
+		return finderInfo, nil, nil
+	}
+	return finderInfo, struct {
+		io.Reader
+		io.Closer
+	}{forkSection, file}, nil
+}
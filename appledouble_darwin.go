@@ -0,0 +1,50 @@
+package dirhash
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// nativeForks returns the Finder information of the file or directory at path, and a reader for
+// its resource fork, either of which is nil if it has none, as kept by the filesystem.
+func nativeForks(path string) ([]byte, io.ReadCloser, error) {
+	finderInfo, err := getFinderInfo(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The resource fork can be read like a file, by way of a special name beneath the file's
+	fork, err := os.Open(path + "/..namedfork/rsrc")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			return finderInfo, nil, nil
+		}
+		return nil, nil, err
+	}
+	return finderInfo, fork, nil
+}
+
+// getFinderInfo returns the "com.apple.FinderInfo" extended attribute of the file at path, or
+// nil if it doesn't have one.
+func getFinderInfo(path string) ([]byte, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+	name, _ := syscall.BytePtrFromString("com.apple.FinderInfo")
+	buf := make([]byte, finderInfoSize)
+	n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	switch errno {
+	case 0:
+		return buf[:n], nil
+	case syscall.ENOATTR, syscall.ENOTSUP, syscall.EPERM: // EPERM where the object can't have it
+		return nil, nil
+	default:
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: errno}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !darwin
+
+package dirhash
+
+import "io"
+
+// nativeForks always reports no Finder information or resource fork, since only macOS keeps
+// them in the filesystem.
+func nativeForks(path string) ([]byte, io.ReadCloser, error) {
+	return nil, nil, nil
+}
@@ -0,0 +1,301 @@
+package dirhash
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// This is a compact, portable implementation of the BLAKE3 hash function in its default hashing
+// mode with 32-byte output, following the reference implementation in the BLAKE3 paper, with
+// the subtrees of large inputs hashed in parallel.
+
+const (
+	blake3ChunkLen   = 1024
+	blake3BlockLen   = 64
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for round := 0; round < 7; round++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+
+		var permuted [16]uint32
+		for i, j := range blake3Permutation {
+			permuted[i] = m[j]
+		}
+		m = permuted
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// blake3Output is everything needed to compute a node's chaining value, or the root output.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	var cv [8]uint32
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o *blake3Output) rootBytes(out []byte) []byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	for _, word := range s[:8] {
+		out = binary.LittleEndian.AppendUint32(out, word)
+	}
+	return out
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// blake3Chunk accumulates the input for a single 1KiB chunk.
+type blake3Chunk struct {
+	cv               [8]uint32
+	counter          uint64
+	buf              [blake3BlockLen]byte
+	bufLen           int
+	blocksCompressed int
+}
+
+func newBlake3Chunk(counter uint64) blake3Chunk {
+	return blake3Chunk{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3Chunk) len() int {
+	return blake3BlockLen*c.blocksCompressed + c.bufLen
+}
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) update(p []byte) {
+	for len(p) > 0 {
+		// Only compress a full block once more input arrives, since the last block is special
+		if c.bufLen == blake3BlockLen {
+			block := blake3Words(c.buf[:])
+			s := blake3Compress(&c.cv, &block, c.counter, blake3BlockLen, c.startFlag())
+			copy(c.cv[:], s[:8])
+			c.blocksCompressed++
+			c.bufLen = 0
+		}
+		n := copy(c.buf[c.bufLen:], p)
+		c.bufLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3Chunk) output() blake3Output {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], c.buf[:c.bufLen])
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(buf[:]),
+		counter:  c.counter,
+		blockLen: uint32(c.bufLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+func blake3Words(b []byte) [16]uint32 {
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return words
+}
+
+// blake3Tree hashes input one chunk after another, folding each finished chunk into the tree.
+type blake3Tree struct {
+	chunk   blake3Chunk
+	cvStack [][8]uint32 // The chaining values of completed subtrees, awaiting their siblings
+}
+
+func (t *blake3Tree) write(p []byte) {
+	for len(p) > 0 {
+		// Once a chunk fills up and more input arrives, fold it into the tree and start another
+		if t.chunk.len() == blake3ChunkLen {
+			out := t.chunk.output()
+			t.pushSubtree(out.chainingValue(), t.chunk.counter+1)
+			t.chunk = newBlake3Chunk(t.chunk.counter + 1)
+		}
+		n := blake3ChunkLen - t.chunk.len()
+		if n > len(p) {
+			n = len(p)
+		}
+		t.chunk.update(p[:n])
+		p = p[n:]
+	}
+}
+
+// pushSubtree adds the chaining value of a finished subtree to the tree, merging completed
+// subtrees for as many trailing zero bits as the new total number of subtrees of its size has.
+// Every subtree pushed must be the same size as or smaller than the one before.
+func (t *blake3Tree) pushSubtree(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		left := t.cvStack[len(t.cvStack)-1]
+		t.cvStack = t.cvStack[:len(t.cvStack)-1]
+		parent := blake3ParentOutput(left, cv)
+		cv = parent.chainingValue()
+		total >>= 1
+	}
+	t.cvStack = append(t.cvStack, cv)
+}
+
+func (t *blake3Tree) sum(in []byte) []byte {
+	out := t.chunk.output()
+	for i := len(t.cvStack) - 1; i >= 0; i-- {
+		out = blake3ParentOutput(t.cvStack[i], out.chainingValue())
+	}
+	return out.rootBytes(in)
+}
+
+// Input is hashed a batch of blake3BatchChunks chunks at a time, with the subtrees of each batch
+// spread across the processors, so that large files are hashed as quickly as the cores allow.
+// A batch is a power of two chunks, and batches always begin at a multiple of its size, so each
+// is a whole subtree of its own.
+const (
+	blake3BatchChunks = 256
+	blake3BatchLen    = blake3BatchChunks * blake3ChunkLen
+)
+
+type blake3 struct {
+	tree blake3Tree // The batches hashed so far, with its chunk ready to begin the next
+	buf  []byte     // The input since, held until a whole batch is known to be followed by more
+
+	// workers is how many processors each batch may be spread across
+	workers int
+}
+
+func newBlake3() hash.Hash {
+	return &blake3{tree: blake3Tree{chunk: newBlake3Chunk(0)}, workers: runtime.GOMAXPROCS(0)}
+}
+
+func (b *blake3) Size() int      { return 32 }
+func (b *blake3) BlockSize() int { return blake3BlockLen }
+
+func (b *blake3) Reset() {
+	b.tree = blake3Tree{chunk: newBlake3Chunk(0), cvStack: b.tree.cvStack[:0]}
+	b.buf = b.buf[:0]
+}
+
+func (b *blake3) Write(p []byte) (int, error) {
+	length := len(p)
+	for len(p) > 0 {
+		// A batch can only be hashed as a subtree once more input follows it, since the last
+		// chunk of all is finished differently
+		if len(b.buf) == blake3BatchLen {
+			b.pushBatch(b.buf)
+			b.buf = b.buf[:0]
+		}
+		if len(b.buf) == 0 && len(p) > blake3BatchLen {
+			b.pushBatch(p[:blake3BatchLen])
+			p = p[blake3BatchLen:]
+			continue
+		}
+		n := min(blake3BatchLen-len(b.buf), len(p))
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+	}
+	return length, nil
+}
+
+// pushBatch hashes a whole batch of input, which is known not to be the last, into the tree.
+func (b *blake3) pushBatch(p []byte) {
+	counter := b.tree.chunk.counter
+	cv := blake3Subtree(p, counter, b.workers)
+	b.tree.pushSubtree(cv, counter/blake3BatchChunks+1)
+	b.tree.chunk = newBlake3Chunk(counter + blake3BatchChunks)
+}
+
+// blake3Subtree returns the chaining value of the subtree made up of the chunks in p, which are
+// a power of two in number beginning with the chunk numbered counter, hashing its two halves
+// concurrently for as long as there are workers to share them between.
+func blake3Subtree(p []byte, counter uint64, workers int) [8]uint32 {
+	if len(p) == blake3ChunkLen {
+		chunk := newBlake3Chunk(counter)
+		chunk.update(p)
+		out := chunk.output()
+		return out.chainingValue()
+	}
+
+	half := len(p) / 2
+	var left, right [8]uint32
+	if workers > 1 {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			left = blake3Subtree(p[:half], counter, workers/2)
+		}()
+		right = blake3Subtree(p[half:], counter+uint64(half/blake3ChunkLen), workers-workers/2)
+		wg.Wait()
+	} else {
+		left = blake3Subtree(p[:half], counter, 1)
+		right = blake3Subtree(p[half:], counter+uint64(half/blake3ChunkLen), 1)
+	}
+	parent := blake3ParentOutput(left, right)
+	return parent.chainingValue()
+}
+
+func (b *blake3) Sum(in []byte) []byte {
+	// Whatever is left over is hashed a chunk at a time, into a copy of the tree so that more
+	// may still be written afterwards
+	t := blake3Tree{chunk: b.tree.chunk, cvStack: append([][8]uint32(nil), b.tree.cvStack...)}
+	t.write(b.buf)
+	return t.sum(in)
+}
@@ -0,0 +1,88 @@
+package dirhash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// blake3Input returns n bytes of the repeating pattern used by the official BLAKE3 test vectors.
+func blake3Input(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i % 251)
+	}
+	return p
+}
+
+func TestBLAKE3Vectors(t *testing.T) {
+	for _, test := range []struct {
+		input []byte
+		want  string
+	}{
+		{nil, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{[]byte("abc"), "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+	} {
+		h := newBlake3()
+		h.Write(test.input)
+		if got := hex.EncodeToString(h.Sum(nil)); got != test.want {
+			t.Errorf("BLAKE3(%q) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+// TestBLAKE3Parallel checks that hashing batches in parallel gives the same hash as hashing
+// every chunk one after another, however the input is split between writes.
+func TestBLAKE3Parallel(t *testing.T) {
+	for _, n := range []int{
+		0, 1, blake3ChunkLen, blake3ChunkLen + 1,
+		blake3BatchLen - 1, blake3BatchLen, blake3BatchLen + 1,
+		2 * blake3BatchLen, 3*blake3BatchLen + 517, 9*blake3BatchLen - blake3ChunkLen,
+	} {
+		input := blake3Input(n)
+		serial := blake3Tree{chunk: newBlake3Chunk(0)}
+		serial.write(input)
+		want := serial.sum(nil)
+
+		for _, split := range []int{n + 1, 32 * 1024, 1000, blake3BatchLen + 3} {
+			// There need not be several processors to share the batches between goroutines
+			h := newBlake3().(*blake3)
+			h.workers = 4
+			for p := input; len(p) > 0; {
+				k := min(split, len(p))
+				h.Write(p[:k])
+				p = p[k:]
+			}
+			if got := h.Sum(nil); !bytes.Equal(got, want) {
+				t.Errorf("%d bytes in writes of %d: hash is %x, want %x", n, split, got, want)
+			}
+
+			// Summing partway leaves the hasher able to carry on
+			h.Reset()
+			h.Write(input[:n/2])
+			h.Sum(nil)
+			h.Write(input[n/2:])
+			if got := h.Sum(nil); !bytes.Equal(got, want) {
+				t.Errorf("%d bytes summed halfway: hash is %x, want %x", n, got, want)
+			}
+		}
+	}
+}
+
+// BenchmarkBLAKE3 compares hashing a large input a chunk at a time with spreading its batches
+// across more and more workers, which only goes faster with as many processors to run them.
+func BenchmarkBLAKE3(b *testing.B) {
+	input := blake3Input(16 << 20)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				h := newBlake3().(*blake3)
+				h.workers = workers
+				h.Write(input)
+				h.Sum(nil)
+			}
+		})
+	}
+}
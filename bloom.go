@@ -0,0 +1,87 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// bloomFalsePositiveRate is the false positive rate HashDirWithBloom sizes its filters for.
+const bloomFalsePositiveRate = 0.01
+
+// Bloom is a Bloom filter holding the content hashes of every file in a tree, answering whether
+// a file with some given contents might be in the tree without keeping every hash around.
+type Bloom struct {
+	bits []uint64
+	k    int // The number of bits set for each hash
+}
+
+// HashDirWithBloom hashes the directory at path like HashDir, and also returns a Bloom filter
+// of the hash of every file in it. The filter is sized once the number of files is known, so
+// as to give false positives about 1% of the time, which takes a little under 10 bits per file.
+func HashDirWithBloom(path string) ([]byte, *Bloom, error) {
+	var mu sync.Mutex
+	var sums [][]byte
+	opts := Options{OnFile: func(e Entry) {
+		mu.Lock()
+		sums = append(sums, e.Sum)
+		mu.Unlock()
+	}}
+
+	hash, err := HashDirWithOptions(path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bloom := newBloom(len(sums), bloomFalsePositiveRate)
+	for _, sum := range sums {
+		bloom.add(sum)
+	}
+	return hash, bloom, nil
+}
+
+// newBloom returns an empty filter sized for n entries with the given false positive rate.
+func newBloom(n int, rate float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{bits: make([]uint64, (int(m)+63)/64), k: k}
+}
+
+// MightContain reports whether a file with the given hash might be in the tree. A false answer
+// is definite, but a true answer is occasionally given for hashes which were never added.
+func (b *Bloom) MightContain(hash []byte) bool {
+	for _, i := range b.positions(hash) {
+		if b.bits[i/64]&(1<<(i%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) add(hash []byte) {
+	for _, i := range b.positions(hash) {
+		b.bits[i/64] |= 1 << (i % 64)
+	}
+}
+
+// positions returns the k bits belonging to hash, found by double hashing over a SHA256 of the
+// hash, so that digests of any length and quality spread evenly over the filter.
+func (b *Bloom) positions(hash []byte) []uint64 {
+	sum := sha256.Sum256(hash)
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	m := uint64(len(b.bits) * 64)
+
+	positions := make([]uint64, b.k)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % m
+	}
+	return positions
+}
@@ -0,0 +1,183 @@
+package dirhash
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A HashCache remembers the hashes of files between runs, in a flat file on disk, so that
+// hashing a large tree over again only reads the files which have changed since. Files are
+// identified by their device and inode numbers, and are assumed unchanged for as long as their
+// size and modification time stay the same, much as git and make assume. Anything which
+// rewrites a file while preserving both, or a filesystem with unreliable inode numbers, can
+// defeat it, so it is best suited to build caches and working copies rather than guarding
+// against tampering.
+//
+// Only regular files on the local disk are cached, and only on systems with inode numbers;
+// elsewhere, or while a Transform, NewHash, or HashAndCopy is in use, every file is hashed as
+// usual. A HashCache may be shared by several hashes at once.
+type HashCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+// cacheKey identifies the contents of a file, as hashed in a particular way.
+type cacheKey struct {
+	dev, ino  uint64
+	size      int64
+	mtime     int64  // Nanoseconds since the Unix epoch
+	algorithm string // The algorithm and any options which change the hash of a file's contents
+}
+
+type cacheEntry struct {
+	hash []byte
+	used bool // Whether the entry was looked up or added since the cache was opened
+}
+
+// cacheHeader begins every cache file, and changes whenever the format does.
+const cacheHeader = "# dirhash cache v1"
+
+// cacheGracePeriod is how old a file's modification time must be before its hash is cached.
+// Any newer, and the file could yet be changed again within the same tick of a coarse clock
+// without its modification time changing.
+const cacheGracePeriod = 2 * time.Second
+
+// OpenHashCache loads the cache stored in the file at path, or starts an empty one if there is
+// no such file yet. Nothing is written back until Save is called.
+func OpenHashCache(path string) (*HashCache, error) {
+	c := &HashCache{path: path, entries: make(map[cacheKey]*cacheEntry)}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || scanner.Text() != cacheHeader {
+		return nil, fmt.Errorf("%s: not a dirhash cache, or written by an incompatible version", path)
+	}
+	for line := 2; scanner.Scan(); line++ {
+		var key cacheKey
+		var sum string
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d %d %s %s", &key.dev, &key.ino, &key.size, &key.mtime, &key.algorithm, &sum); err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed cache entry", path, line)
+		}
+		hash, err := hex.DecodeString(sum)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed cache entry", path, line)
+		}
+		c.entries[key] = &cacheEntry{hash: hash}
+	}
+	return c, scanner.Err()
+}
+
+// Save writes the cache back to the file it was opened from. The file is replaced atomically,
+// so a cache is never left half written.
+func (c *HashCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	temp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	out := bufio.NewWriter(temp)
+	fmt.Fprintln(out, cacheHeader)
+	for key, entry := range c.entries {
+		fmt.Fprintf(out, "%d %d %d %d %s %x\n", key.dev, key.ino, key.size, key.mtime, key.algorithm, entry.hash)
+	}
+	if err := out.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), c.path)
+}
+
+// Prune forgets every entry which hasn't been used since the cache was opened or last pruned,
+// such as those for files which have since been changed or deleted, so that the cache doesn't
+// keep growing. It should only be called after hashing everything the cache is meant to cover.
+func (c *HashCache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if !entry.used {
+			delete(c.entries, key)
+		}
+		entry.used = false
+	}
+}
+
+func (c *HashCache) lookup(key cacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry.used = true
+	return entry.hash, true
+}
+
+func (c *HashCache) add(key cacheKey, hash []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{hash: hash, used: true}
+}
+
+// cachedHash returns the hash of the file described by info from the cache if it can, or else
+// computes it with hash and remembers it for next time.
+func (w *walker) cachedHash(info os.FileInfo, hash func() ([]byte, error)) ([]byte, error) {
+	key, ok := w.cacheKey(info)
+	if !ok {
+		return hash()
+	}
+	if sum, ok := w.opts.Cache.lookup(key); ok {
+		return sum, nil
+	}
+
+	sum, err := hash()
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) > cacheGracePeriod {
+		w.opts.Cache.add(key, sum)
+	}
+	return sum, nil
+}
+
+// cacheKey returns the key of the file described by info, if its hash may be cached at all.
+func (w *walker) cacheKey(info os.FileInfo) (cacheKey, bool) {
+	if w.opts.Cache == nil || w.opts.Transform != nil || w.opts.NewHash != nil || w.copyTo != "" || w.fsys != nil {
+		return cacheKey{}, false
+	}
+	if !info.Mode().IsRegular() {
+		return cacheKey{}, false
+	}
+	dev, ino, ok := fileID(info)
+	if !ok {
+		return cacheKey{}, false
+	}
+
+	algorithm := w.opts.Algorithm.String()
+	if w.opts.DomainSeparateNodes {
+		algorithm += "+tagged"
+	}
+	if w.opts.ChunkSize > 0 && info.Size() > w.opts.ChunkSize {
+		algorithm += fmt.Sprintf("+chunked%d", w.opts.ChunkSize)
+	}
+	return cacheKey{dev, ino, info.Size(), info.ModTime().UnixNano(), algorithm}, true
+}
@@ -0,0 +1,264 @@
+package dirhash
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Checkpoint records the hash of every directory as soon as the directory has been hashed,
+// in a file on disk, so that a hash of a huge tree which is interrupted, whether by a crash, a
+// power cut, or the user, can be resumed where it left off rather than started again from
+// scratch. Resuming takes each directory recorded at its word, without reading anything in it,
+// so anything changed in the meantime within a directory which was already finished goes
+// unnoticed: the tree should be left alone until the hash is complete.
+//
+// Each directory is written out as soon as it is finished, so at most the directories being
+// hashed at the time are lost, however the hash was interrupted. A checkpoint belongs to a
+// single hash of a single directory, and refuses to resume one of another directory, or with
+// other options which change the hash.
+type Checkpoint struct {
+	path    string
+	mu      sync.Mutex
+	file    *os.File
+	key     string            // The hash and directory the checkpoint belongs to, once known
+	end     int64             // The end of the last complete line in the file
+	entries map[string][]byte // The hash of each finished directory, keyed by its path relative to the root
+}
+
+// checkpointHeader begins every checkpoint file, and changes whenever the format does. The line
+// after it identifies the hash the checkpoint belongs to.
+const (
+	checkpointHeader    = "# dirhash checkpoint v1"
+	checkpointKeyPrefix = "# key "
+)
+
+// ErrCheckpointMismatch is returned when a checkpoint is used to resume a hash of another
+// directory, or a hash with different options, from the one it was written for.
+var ErrCheckpointMismatch = errors.New("checkpoint was written for another directory or other options")
+
+// OpenCheckpoint loads the checkpoint stored in the file at path, or starts a new one if there
+// is no such file yet, ready to be given as Options.Checkpoint. Directories are added to the
+// file as they are hashed; once the hash has succeeded the checkpoint has served its purpose,
+// and Remove deletes it.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, entries: make(map[string][]byte)}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	c.file = file
+	return c, nil
+}
+
+// load reads the contents of an existing checkpoint file. The last line may have been cut short
+// by whatever interrupted the hash, and is then ignored along with anything after it, to be
+// overwritten when the hash resumes.
+func (c *Checkpoint) load(file *os.File) error {
+	in := bufio.NewReader(file)
+	readLine := func() (string, bool, error) {
+		line, err := in.ReadString('\n')
+		if err == io.EOF {
+			return "", false, nil // Nothing more, or only the torn end of a line
+		}
+		if err != nil {
+			return "", false, err
+		}
+		c.end += int64(len(line))
+		return strings.TrimSuffix(line, "\n"), true, nil
+	}
+
+	header, ok, err := readLine()
+	if err != nil || !ok {
+		return err // A new checkpoint, or one which died before getting anywhere
+	}
+	if header != checkpointHeader {
+		return fmt.Errorf("%s: not a dirhash checkpoint, or written by an incompatible version", c.path)
+	}
+	key, ok, err := readLine()
+	if err != nil || !ok || !strings.HasPrefix(key, checkpointKeyPrefix) {
+		return err
+	}
+	c.key = key[len(checkpointKeyPrefix):]
+	for {
+		end := c.end
+		line, ok, err := readLine()
+		if err != nil || !ok {
+			return err
+		}
+		sum, rel, ok := parseCheckpointLine(line)
+		if !ok {
+			c.end = end
+			return nil
+		}
+		c.entries[rel] = sum
+	}
+}
+
+// parseCheckpointLine decodes a line of the form "<hex> <quoted path>".
+func parseCheckpointLine(line string) ([]byte, string, bool) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return nil, "", false
+	}
+	sum, err := hex.DecodeString(line[:i])
+	if err != nil || len(sum) == 0 {
+		return nil, "", false
+	}
+	rel, err := strconv.Unquote(line[i+1:])
+	if err != nil {
+		return nil, "", false
+	}
+	return sum, rel, true
+}
+
+// Close closes the checkpoint file, keeping it for a later hash to resume from.
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// Remove closes the checkpoint file and deletes it, once the hash it was kept for is complete.
+func (c *Checkpoint) Remove() error {
+	if err := c.Close(); err != nil {
+		return err
+	}
+	return os.Remove(c.path)
+}
+
+// start makes sure the checkpoint belongs to the hash identified by key, writing the header of
+// a new checkpoint file if it has just been created. A checkpoint file which was never given a
+// key is started over.
+func (c *Checkpoint) start(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return os.ErrClosed
+	}
+	if c.key != "" {
+		if c.key != key {
+			return &os.PathError{Op: "resume", Path: c.path, Err: ErrCheckpointMismatch}
+		}
+		// Whatever was cut short at the end is overwritten from here on
+		if err := c.file.Truncate(c.end); err != nil {
+			return err
+		}
+		_, err := c.file.Seek(c.end, io.SeekStart)
+		return err
+	}
+	if err := c.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := c.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	c.key, c.entries = key, make(map[string][]byte)
+	_, err := fmt.Fprintf(c.file, "%s\n%s%s\n", checkpointHeader, checkpointKeyPrefix, key)
+	return err
+}
+
+// lookup returns the hash of the directory at the relative path rel, if it is recorded.
+func (c *Checkpoint) lookup(rel string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sum, ok := c.entries[rel]
+	return sum, ok
+}
+
+// add records the hash of the directory at the relative path rel, writing it straight out to
+// the file so that it survives the process being killed.
+func (c *Checkpoint) add(rel string, sum []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return os.ErrClosed
+	}
+	c.entries[rel] = sum
+	_, err := fmt.Fprintf(c.file, "%X %s\n", sum, strconv.Quote(rel))
+	return err
+}
+
+// checkpointKey identifies the hash of the tree at root with the options in effect, for a
+// checkpoint to resume only the same hash. Only the options which can change the hash of a
+// directory are part of it.
+func (w *walker) checkpointKey(root string) (string, error) {
+	if w.fsys == nil {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		root = abs
+	}
+	o := w.opts
+	desc := fmt.Sprintf("root=%q algorithm=%s skipsystem=%t exclude=%q include=%q ignore=%q symlinks=%s special=%s maxdepth=%d onefs=%t chunksize=%d structure=%t shape=%t collapse=%t rootmtime=%t metadata=%s tagged=%t capabilities=%t xattrs=%t streams=%t appledouble=%s",
+		root, o.Algorithm, o.SkipSystemDirs, o.Exclude, o.Include, o.IgnoreFiles, o.Symlinks, o.SpecialFiles, o.MaxDepth, o.OneFileSystem,
+		o.ChunkSize, o.StructureOnly, w.shape, o.CollapseChains, o.IncludeRootMtime, o.Metadata, o.DomainSeparateNodes, o.IncludeCapabilities, o.IncludeXattrs, o.IncludeStreams, o.AppleDouble)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(desc))), nil
+}
+
+// startCheckpoint readies the Checkpoint, if there is one, for the hash of the tree at root.
+// Directories taken from a checkpoint are never visited, so it can only be used where nothing
+// needs to see every file and directory.
+func (w *walker) startCheckpoint(root string) error {
+	if w.opts.Checkpoint == nil {
+		return nil
+	}
+	switch {
+	case w.opts.NewHash != nil || w.opts.Transform != nil:
+		return errors.New("a checkpoint can't be used with NewHash or Transform, since it can't tell whether they have changed")
+	case w.opts.OnFile != nil || w.onFile != nil || w.onDir != nil || w.proofDirs != nil:
+		return errors.New("a checkpoint can't be used where every file must be seen, as for a manifest, a tree, or a proof")
+	case w.copyTo != "" || w.packTo != nil:
+		return errors.New("a checkpoint can't be used while copying or archiving the tree")
+	}
+	key, err := w.checkpointKey(root)
+	if err != nil {
+		return err
+	}
+	return w.opts.Checkpoint.start(key)
+}
+
+// restoreDir returns the hash of the directory at path from the Checkpoint, if it is recorded.
+func (w *walker) restoreDir(path string) ([]byte, bool) {
+	if w.opts.Checkpoint == nil || w.opts.ShellSortCompat || w.opts.ContentOnly {
+		return nil, false
+	}
+	return w.opts.Checkpoint.lookup(w.checkpointPath(path))
+}
+
+// checkpointDir records the hash of the directory at path in the Checkpoint, if there is one.
+// Once anything has been skipped under OnError nothing more is recorded, since a directory
+// holding a skipped entry must be read again for the skip to be reported again.
+func (w *walker) checkpointDir(path string, hash []byte) error {
+	if w.opts.Checkpoint == nil || w.skippedAny.Load() {
+		return nil
+	}
+	return w.opts.Checkpoint.add(w.checkpointPath(path), hash)
+}
+
+// checkpointPath returns the path of a directory relative to the root, as it is recorded in a
+// checkpoint, which is empty for the root itself.
+func (w *walker) checkpointPath(path string) string {
+	if path == w.root {
+		return ""
+	}
+	return w.relativePath(path)
+}
@@ -0,0 +1,142 @@
+package dirhash
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// hashChunks hashes everything read from r in chunks of ChunkSize bytes, returning the hash of
+// the whole along with the hashes of the chunks it was combined from. Anything no longer than a
+// single chunk is hashed whole, just as without ChunkSize, and has no chunks of its own.
+func (w *walker) hashChunks(r io.Reader) ([]byte, [][]byte, error) {
+	var chunks [][]byte
+	for {
+		hasher := w.newHash()
+		if w.opts.DomainSeparateNodes {
+			hasher.Write([]byte{leafPrefix})
+		}
+		n, err := io.CopyN(hasher, r, w.opts.ChunkSize)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if n > 0 || len(chunks) == 0 {
+			chunks = append(chunks, hasher.Sum(nil))
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(chunks) == 1 {
+		return chunks[0], nil, nil
+	}
+	return w.combineChunks(chunks), chunks, nil
+}
+
+// combineChunks returns the hash of a file made up of the chunks with the given hashes: the hash
+// of a pseudo-file giving the chunk size, followed by the hash of each chunk in turn.
+func (w *walker) combineChunks(chunks [][]byte) []byte {
+	return w.hashPseudoFile(func(out io.Writer) {
+		fmt.Fprintf(out, "chunksize=%d\n", w.opts.ChunkSize)
+		for _, chunk := range chunks {
+			fmt.Fprintf(out, "%X\n", chunk)
+		}
+	})
+}
+
+// takeChunks returns the chunk hashes recorded when the file at path was last read, if it was
+// long enough to have several, and forgets them.
+func (w *walker) takeChunks(path string) [][]byte {
+	chunks, ok := w.chunks.LoadAndDelete(path)
+	if !ok {
+		return nil
+	}
+	return chunks.([][]byte)
+}
+
+// badChunks returns the indexes of the chunks which differ between two lists of chunk hashes
+// for the same file, including any which are only in one of them.
+func badChunks(listed, found [][]byte) []int {
+	var bad []int
+	for i := 0; i < len(listed) || i < len(found); i++ {
+		if i >= len(listed) || i >= len(found) || !bytes.Equal(listed[i], found[i]) {
+			bad = append(bad, i)
+		}
+	}
+	return bad
+}
+
+// ErrChunkSize is returned by VerifyChunks when there is no chunk size to verify with.
+var ErrChunkSize = errors.New("chunk size must be positive")
+
+// VerifyChunks checks the file at path a chunk at a time against chunks, the hashes of its
+// chunks as listed in a manifest written with the given chunk size and algorithm, beginning
+// with chunk first. This lets even an enormous file be checked piecemeal, and a check which was
+// interrupted be picked up again from the last chunk it reached, rather than from the start.
+// The check is reported chunk by chunk to onChunk, with the index of each chunk counting from
+// the start of the file and whether it matches; chunks which are listed but past the end of the
+// file, or which are in the file but not listed, don't match. Returning an error from onChunk
+// ends the check, and that error is returned. The result doesn't depend on chunk boundaries
+// lining up with anything else, but the chunk size must be the one the manifest was written
+// with, as recorded by Manifest.ChunkSize.
+func VerifyChunks(path string, chunkSize int64, algorithm Algorithm, chunks [][]byte, first int, onChunk func(index int, ok bool) error) error {
+	if chunkSize <= 0 {
+		return ErrChunkSize
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(int64(first)*chunkSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	in := bufio.NewReaderSize(file, 64*1024)
+	next := first
+	for ; ; next++ {
+		hasher := algorithm.New()
+		n, err := io.CopyN(hasher, in, chunkSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 && next > 0 {
+			break // Only an empty file has an empty chunk
+		}
+		ok := next < len(chunks) && bytes.Equal(hasher.Sum(nil), chunks[next])
+		if err := onChunk(next, ok); err != nil {
+			return err
+		}
+		if err == io.EOF {
+			next++
+			break
+		}
+	}
+
+	// Whatever is listed past the end of the file is missing from it
+	for ; next < len(chunks); next++ {
+		if err := onChunk(next, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffChunks returns the chunks which differ between older and newer of each of the modified
+// files whose chunks are known to both, or nil if there are none.
+func diffChunks(modified []string, older, newer map[string][][]byte) map[string][]int {
+	var diff map[string][]int
+	for _, path := range modified {
+		if older[path] == nil || newer[path] == nil {
+			continue
+		}
+		if diff == nil {
+			diff = make(map[string][]int)
+		}
+		diff[path] = badChunks(older[path], newer[path])
+	}
+	return diff
+}
@@ -0,0 +1,141 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// AlgorithmComparison holds the results of hashing one tree with two different algorithms.
+type AlgorithmComparison struct {
+	A, B       Algorithm
+	SumA, SumB []byte // The hash of the tree under each algorithm
+
+	// Structure is a SHA256 fingerprint of the entry names and their order alone, common to both
+	// algorithms. It is the hash of the same listings with every file hash replaced by "-", and
+	// every directory hash by that directory's own structural fingerprint, just as the tree would
+	// hash under StructureOnly.
+	Structure []byte
+}
+
+// CompareAlgorithms hashes the tree at path with both a and b in a single traversal, and returns
+// the hash under each along with the structural fingerprint they share. Every file is read once,
+// with its contents going into both hashes, and the pseudo-file of every directory is built once
+// and written out with the digests of each algorithm in turn, so the two hashes describe exactly
+// the same listing even if the tree is changing underneath. This is meant for catching
+// nondeterminism, and for reassuring anyone moving between algorithms that nothing besides the
+// digest function has changed.
+func CompareAlgorithms(path string, a, b Algorithm) (*AlgorithmComparison, error) {
+	return CompareAlgorithmsWithOptions(path, Options{}, a, b)
+}
+
+// CompareAlgorithmsWithOptions is CompareAlgorithms, hashing the tree according to opts but
+// with a and b in place of opts.Algorithm. OnFile is given each file's hash under a. Any option
+// which writes hashes into a pseudo-file anywhere but at the start of a line, or has no
+// pseudo-file per directory at all, can't be split between the algorithms and is refused; a
+// Checkpoint is ignored, since it only holds hashes under one algorithm.
+func CompareAlgorithmsWithOptions(path string, opts Options, a, b Algorithm) (*AlgorithmComparison, error) {
+	switch {
+	case opts.ShellSortCompat || opts.ContentOnly || opts.StructureOnly:
+		return nil, errors.New("cannot compare algorithms in ShellSortCompat, ContentOnly, or StructureOnly mode, which have no pseudo-file per directory")
+	case opts.ChunkSize > 0 || opts.IncludeStreams || opts.AppleDouble == AppleDoubleMerge:
+		return nil, errors.New("cannot compare algorithms with ChunkSize, IncludeStreams, or AppleDoubleMerge, which record hashes within their pseudo-files")
+	}
+	c := &comparison{algorithms: [2]Algorithm{a, b}, sizes: [3]int{a.DigestSize(), b.DigestSize(), sha256.Size}}
+
+	// Every file is hashed by both algorithms at once, so its hash is the two digests together
+	opts.NewHash = func() hash.Hash { return newPairHash(a.New(), b.New()) }
+	opts.Checkpoint = nil
+	if onFile := opts.OnFile; onFile != nil {
+		opts.OnFile = func(e Entry) {
+			e.Sum = e.Sum[:c.sizes[0]]
+			onFile(e)
+		}
+	}
+
+	w := newWalker(&opts)
+	w.compare = c
+	sum, err := w.run(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(sum) != c.sizes[0]+c.sizes[1]+c.sizes[2] {
+		return nil, fmt.Errorf("tree hash is %d bytes long, not the %d of both algorithms and a fingerprint", len(sum), c.sizes[0]+c.sizes[1]+c.sizes[2])
+	}
+	return &AlgorithmComparison{
+		A:         a,
+		B:         b,
+		SumA:      sum[:c.sizes[0]],
+		SumB:      sum[c.sizes[0] : c.sizes[0]+c.sizes[1]],
+		Structure: sum[c.sizes[0]+c.sizes[1]:],
+	}, nil
+}
+
+// comparison holds what a walker needs to hash every directory under two algorithms at once,
+// for CompareAlgorithms. Each file's hash is the digests of the two algorithms side by side, and
+// each directory's hash the same followed by its structural fingerprint.
+type comparison struct {
+	algorithms [2]Algorithm
+	sizes      [3]int // The lengths of the digests under each algorithm, then the fingerprint
+}
+
+// hashPseudoFiles hashes the pseudo-file of a directory with the given header and entries once
+// for each algorithm and once for its structural fingerprint, filling in each entry's part of
+// its hash in turn, and returns the three hashes side by side.
+func (c *comparison) hashPseudoFiles(w *walker, header string, entries []dirEntry) ([]byte, error) {
+	var sum []byte
+	part := make([]dirEntry, len(entries))
+	for k := range c.sizes {
+		offset := 0
+		for i := 0; i < k; i++ {
+			offset += c.sizes[i]
+		}
+		for i, e := range entries {
+			if e.sum != nil {
+				size := c.sizes[0] + c.sizes[1]
+				if e.dir {
+					size += c.sizes[2]
+				}
+				if len(e.sum) != size {
+					return nil, fmt.Errorf("hash of %q is %d bytes long, not the %d expected", e.name, len(e.sum), size)
+				}
+				if offset < len(e.sum) {
+					e.hash = fmt.Sprintf("%X", e.sum[offset:offset+c.sizes[k]])
+				} else {
+					e.hash = structurePlaceholder
+				}
+			}
+			part[i] = e
+		}
+
+		var hasher hash.Hash
+		if k < len(c.algorithms) {
+			hasher = c.algorithms[k].New()
+		} else {
+			hasher = sha256.New()
+		}
+		sum = append(sum, w.hashPseudoFileWith(hasher, func(out io.Writer) {
+			io.WriteString(out, header)
+			writeEntries(out, part)
+		})...)
+	}
+	return sum, nil
+}
+
+// pairHash feeds everything written to it into two hashes at once, and sums to both of their
+// digests side by side.
+type pairHash struct {
+	io.Writer
+	a, b hash.Hash
+}
+
+func newPairHash(a, b hash.Hash) *pairHash {
+	return &pairHash{Writer: io.MultiWriter(a, b), a: a, b: b}
+}
+
+func (p *pairHash) Sum(in []byte) []byte { return p.b.Sum(p.a.Sum(in)) }
+func (p *pairHash) Reset()               { p.a.Reset(); p.b.Reset() }
+func (p *pairHash) Size() int            { return p.a.Size() + p.b.Size() }
+func (p *pairHash) BlockSize() int       { return p.a.BlockSize() }
@@ -0,0 +1,186 @@
+package dirhash
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestCompareAlgorithms(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":       "alpha",
+		"sub/b.txt":   "beta",
+		"sub/deeper/": "",
+		"z":           "",
+	})
+
+	c, err := CompareAlgorithms(root, AlgorithmSHA256, AlgorithmSHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.A != AlgorithmSHA256 || c.B != AlgorithmSHA512 {
+		t.Errorf("algorithms are %v and %v, want sha256 and sha512", c.A, c.B)
+	}
+	for _, sum := range []struct {
+		algorithm Algorithm
+		got       []byte
+	}{{AlgorithmSHA256, c.SumA}, {AlgorithmSHA512, c.SumB}} {
+		want, err := HashDirWithOptions(root, Options{Algorithm: sum.algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sum.got, want) {
+			t.Errorf("%v hash is %X, want %X as from HashDir", sum.algorithm, sum.got, want)
+		}
+	}
+
+	// Changing the contents of a file changes both hashes but not the structure
+	if err := writeFile(root, "a.txt", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := CompareAlgorithms(root, AlgorithmSHA256, AlgorithmSHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(changed.SumA, c.SumA) || bytes.Equal(changed.SumB, c.SumB) {
+		t.Error("changing a file left a hash unchanged")
+	}
+	if !bytes.Equal(changed.Structure, c.Structure) {
+		t.Error("changing a file changed the structure")
+	}
+
+	// Renaming one changes the structure too
+	if err := rename(root, "z", "y"); err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := CompareAlgorithms(root, AlgorithmSHA256, AlgorithmSHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(renamed.Structure, c.Structure) {
+		t.Error("renaming a file left the structure unchanged")
+	}
+}
+
+func TestCompareAlgorithmsMissing(t *testing.T) {
+	if _, err := CompareAlgorithms(t.TempDir()+"/missing", AlgorithmSHA256, AlgorithmSHA512); err == nil {
+		t.Error("comparing a missing directory succeeded")
+	}
+}
+
+func TestCompareAlgorithmsWithOptions(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"keep.txt":  "kept",
+		"skip.log":  "left out",
+		"sub/c.txt": "gamma",
+	})
+	opts := Options{Exclude: []string{"*.log"}}
+
+	c, err := CompareAlgorithmsWithOptions(root, opts, AlgorithmSHA256, AlgorithmBLAKE3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Algorithm = AlgorithmBLAKE3
+	want, err := HashDirWithOptions(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.SumB, want) {
+		t.Errorf("blake3 hash is %X, want %X as from HashDirWithOptions", c.SumB, want)
+	}
+	structure, err := HashDirWithOptions(root, Options{Exclude: opts.Exclude, StructureOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Structure, structure) {
+		t.Errorf("structure is %X, want %X as under StructureOnly", c.Structure, structure)
+	}
+
+	if _, err := CompareAlgorithmsWithOptions(root, Options{ShellSortCompat: true}, AlgorithmSHA256, AlgorithmSHA512); err == nil {
+		t.Error("comparing under ShellSortCompat succeeded")
+	}
+}
+
+// TestCompareAlgorithmsSinglePass checks that every file is read only once for both algorithms,
+// and that the hashes still agree with hashing under each alone whatever the options.
+func TestCompareAlgorithmsSinglePass(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":         "alpha",
+		"only/one/b":    "beta",
+		"sub/c.txt":     "gamma",
+		"sub/d.txt":     "delta",
+		"sub/empty/":    "",
+		"sub/more/e.md": "epsilon",
+	})
+
+	for _, opts := range []Options{
+		{},
+		{DomainSeparateNodes: true},
+		{CollapseChains: true, Metadata: MetadataMode},
+		{MaxDepth: 1, IncludeRootMtime: true},
+		{DirConcurrency: 4, FileConcurrency: 4, DedupContent: true},
+	} {
+		var mu sync.Mutex
+		reads := make(map[string]int)
+		sums := make(map[string][]byte)
+		compareOpts := opts
+		compareOpts.OnFile = func(e Entry) {
+			mu.Lock()
+			reads[e.Path]++
+			sums[e.Path] = e.Sum
+			mu.Unlock()
+		}
+		c, err := CompareAlgorithmsWithOptions(root, compareOpts, AlgorithmSHA512, AlgorithmBLAKE3)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		for path, n := range reads {
+			if n != 1 {
+				t.Errorf("%+v: %s was read %d times", opts, path, n)
+			}
+		}
+
+		for _, sum := range []struct {
+			algorithm Algorithm
+			got       []byte
+		}{{AlgorithmSHA512, c.SumA}, {AlgorithmBLAKE3, c.SumB}} {
+			opts.Algorithm = sum.algorithm
+			want := make(map[string][]byte)
+			opts.OnFile = func(e Entry) {
+				mu.Lock()
+				want[e.Path] = e.Sum
+				mu.Unlock()
+			}
+			wantSum, err := HashDirWithOptions(root, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sum.got, wantSum) {
+				t.Errorf("%+v: hash is %X, want %X", opts, sum.got, wantSum)
+			}
+			if sum.algorithm != AlgorithmSHA512 {
+				continue
+			}
+			for path, hash := range want {
+				if !bytes.Equal(sums[path], hash) {
+					t.Errorf("%+v: OnFile gave %s the hash %X, want %X", opts, path, sums[path], hash)
+				}
+			}
+		}
+		opts.OnFile = nil
+		opts.Algorithm, opts.StructureOnly = AlgorithmSHA256, true
+		structure, err := HashDirWithOptions(root, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c.Structure, structure) {
+			t.Errorf("%+v: structure is %X, want %X as under StructureOnly", opts, c.Structure, structure)
+		}
+	}
+
+	for _, opts := range []Options{{ChunkSize: 4}, {StructureOnly: true}, {ContentOnly: true}} {
+		if _, err := CompareAlgorithmsWithOptions(root, opts, AlgorithmSHA256, AlgorithmSHA512); err == nil {
+			t.Errorf("%+v: comparing algorithms succeeded", opts)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package dirhash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// hashContentOnly hashes the tree at root under ContentOnly: the sorted list of the hashes of
+// every file in it, with names and layout left out entirely.
+func (w *walker) hashContentOnly(root string) ([]byte, error) {
+	if w.opts.StructureOnly {
+		return nil, errors.New("cannot combine ContentOnly with StructureOnly")
+	}
+
+	// Walk the tree as usual, keeping only the hash of each file along the way
+	var mu sync.Mutex
+	var lines []string
+	w.onFile = func(path string, size int64, link bool, hash []byte) error {
+		mu.Lock()
+		lines = append(lines, fmt.Sprintf("%X\n", hash))
+		mu.Unlock()
+		return nil
+	}
+	if _, err := w.hashTree(root); err != nil {
+		return nil, err
+	}
+	sort.Strings(lines)
+
+	hasher := w.newHash()
+	if w.opts.DomainSeparateNodes {
+		hasher.Write([]byte{nodePrefix})
+	}
+	for _, line := range lines {
+		if _, err := hasher.Write([]byte(line)); err != nil {
+			return nil, err
+		}
+	}
+	return hasher.Sum(nil), nil
+}
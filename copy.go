@@ -0,0 +1,98 @@
+package dirhash
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ErrCopyMismatch is returned by HashAndCopy when VerifyCopy finds that the copy doesn't hash
+// the same as the original.
+var ErrCopyMismatch = errors.New("copy does not match the original")
+
+// HashAndCopy hashes the directory at src while copying it to dst, reading each file only
+// once, and returns the hash of src.
+func HashAndCopy(src, dst string) ([]byte, error) {
+	return HashAndCopyWithOptions(src, dst, Options{})
+}
+
+// HashAndCopyWithOptions hashes the directory at src while copying it to dst, reading each
+// file only once, and returns the hash of src according to opts. The directory structure and
+// names are reproduced under dst, which is created if it doesn't already exist, along with
+// the permission bits of every file and directory; existing files are overwritten. Links to
+// files are copied as the files they point to, except under SymlinkHashTarget, where they are
+// copied as links to the same target. If opts.VerifyCopy is set, dst is then hashed in turn
+// and ErrCopyMismatch returned if the two hashes disagree. StructureOnly, ShellSortCompat, and
+// SpecialRecord are refused, since they don't read everything there is to copy.
+func HashAndCopyWithOptions(src, dst string, opts Options) ([]byte, error) {
+	// Content caching would skip the very reads the copy is made from
+	opts.DedupContent = false
+	if opts.StructureOnly || opts.ShellSortCompat {
+		return nil, errors.New("cannot copy in StructureOnly or ShellSortCompat mode")
+	}
+	if opts.SpecialFiles == SpecialRecord {
+		return nil, errors.New("cannot copy special files as recorded by SpecialRecord")
+	}
+
+	w := newWalker(&opts)
+	w.copyTo = dst
+	hash, err := w.run(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.VerifyCopy {
+		opts.OnFile, opts.OnSlowFile, opts.ProgressWithETA = nil, nil, nil
+		copied, err := HashDirWithOptions(dst, opts)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(hash, copied) {
+			return nil, ErrCopyMismatch
+		}
+	}
+	return hash, nil
+}
+
+// copyPath returns the destination of the copy of whatever is at path.
+func (w *walker) copyPath(path string) string {
+	return w.copyTo + strings.TrimPrefix(path, w.root)
+}
+
+// makeCopyDir creates the copy of the directory at path, if it doesn't already exist.
+func (w *walker) makeCopyDir(path string) error {
+	info, err := w.stat(path)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(diskPath(w.copyPath(path)), info.Mode().Perm())
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+// copyLink creates the copy of the link at path, pointing to target, under SymlinkHashTarget.
+// Whatever is already in its place is replaced, just as files are overwritten.
+func (w *walker) copyLink(path, target string) error {
+	dst := diskPath(w.copyPath(path))
+	err := os.Symlink(target, dst)
+	if os.IsExist(err) {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+		err = os.Symlink(target, dst)
+	}
+	return err
+}
+
+// createCopy creates the file to which the copy of file, found at path, is to be written.
+func (w *walker) createCopy(path string, file fs.File) (*os.File, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(diskPath(w.copyPath(path)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+}
@@ -0,0 +1,71 @@
+package dirhash
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashAndCopy(t *testing.T) {
+	src := makeTree(t, map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "beta",
+		"empty/":    "",
+	})
+	dst := filepath.Join(t.TempDir(), "copy")
+
+	hash, err := HashAndCopyWithOptions(src, dst, Options{VerifyCopy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := HashDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, want) {
+		t.Errorf("hash is %X, want %X", hash, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "sub", "b.txt")); err != nil || string(data) != "beta" {
+		t.Errorf("copied sub/b.txt holds %q, %v", data, err)
+	}
+}
+
+// TestHashAndCopyLinks copies links as links under SymlinkHashTarget, including over whatever is
+// already in their place.
+func TestHashAndCopyLinks(t *testing.T) {
+	src := makeTree(t, map[string]string{"a.txt": "alpha"})
+	for name, target := range map[string]string{"link": "a.txt", "dangling": "nowhere"} {
+		if err := os.Symlink(target, filepath.Join(src, name)); err != nil {
+			t.Skip("can't make symbolic links: ", err)
+		}
+	}
+	dst := makeTree(t, map[string]string{"link": "in the way"})
+
+	opts := Options{Symlinks: SymlinkHashTarget, VerifyCopy: true}
+	if _, err := HashAndCopyWithOptions(src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"link": "a.txt", "dangling": "nowhere"} {
+		if target, err := os.Readlink(filepath.Join(dst, name)); err != nil || target != want {
+			t.Errorf("copy of %s points to %q, %v, want %q", name, target, err, want)
+		}
+	}
+}
+
+func TestHashAndCopyRefused(t *testing.T) {
+	src := makeTree(t, map[string]string{"a.txt": "alpha"})
+	for _, opts := range []Options{
+		{StructureOnly: true},
+		{ShellSortCompat: true},
+		{SpecialFiles: SpecialRecord},
+	} {
+		dst := filepath.Join(t.TempDir(), "copy")
+		if _, err := HashAndCopyWithOptions(src, dst, opts); err == nil {
+			t.Errorf("copying with %+v succeeded", opts)
+		}
+		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+			t.Errorf("copying with %+v made the copy anyway", opts)
+		}
+	}
+}
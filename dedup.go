@@ -0,0 +1,149 @@
+package dirhash
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"sync"
+)
+
+const (
+	dedupPrefixSize = 4096 // How much of each file goes into its content key
+	dedupCacheSize  = 4096 // How many distinct content keys are remembered at once
+)
+
+// contentKey identifies files which are likely to have identical contents: their size together
+// with the SHA256 of their first dedupPrefixSize bytes.
+type contentKey struct {
+	size   int64
+	prefix [sha256.Size]byte
+}
+
+// contentCache is a bounded LRU cache mapping content keys to the full hash of a file with that
+// key, along with the path of that file so that later matches can be checked against it.
+type contentCache struct {
+	mu      sync.Mutex
+	order   *list.List // Of *cachedContent, most recently used first
+	entries map[contentKey]*list.Element
+}
+
+type cachedContent struct {
+	key  contentKey
+	path string
+	hash []byte
+}
+
+func newContentCache() *contentCache {
+	return &contentCache{order: list.New(), entries: make(map[contentKey]*list.Element)}
+}
+
+func (c *contentCache) get(key contentKey) (cachedContent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return cachedContent{}, false
+	}
+	c.order.MoveToFront(elem)
+	return *elem.Value.(*cachedContent), true
+}
+
+func (c *contentCache) add(key contentKey, path string, hash []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedContent{key, path, hash})
+	if c.order.Len() > dedupCacheSize {
+		oldest := c.order.Remove(c.order.Back()).(*cachedContent)
+		delete(c.entries, oldest.key)
+	}
+}
+
+// hashDeduplicated hashes the file at path, reusing the hash of an earlier file if the two have
+// exactly the same contents.
+func (w *walker) hashDeduplicated(path string, size int64) ([]byte, error) {
+	key, err := w.readContentKey(path, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// A file no bigger than the prefix is entirely covered by the key, otherwise the contents
+	// must be compared to make sure the match isn't an accident
+	if cached, ok := w.dedup.get(key); ok {
+		same := size <= dedupPrefixSize
+		if !same {
+			same, err = w.sameContents(path, cached.path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if same {
+			return cached.hash, nil
+		}
+	}
+
+	hash, err := w.hashContents(path)
+	if err != nil {
+		return nil, err
+	}
+	w.dedup.add(key, path, hash)
+	return hash, nil
+}
+
+func (w *walker) readContentKey(path string, size int64) (contentKey, error) {
+	file, err := w.open(path)
+	if err != nil {
+		return contentKey{}, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.CopyN(hasher, file, dedupPrefixSize); err != nil && err != io.EOF {
+		return contentKey{}, err
+	}
+	key := contentKey{size: size}
+	hasher.Sum(key.prefix[:0])
+	return key, nil
+}
+
+// sameContents reports whether the files at paths a and b contain exactly the same bytes.
+func (w *walker) sameContents(a, b string) (bool, error) {
+	// Both files are made room for at once, since waiting for the second while holding the
+	// first could wait forever
+	if err := w.acquireFiles(2); err != nil {
+		return false, err
+	}
+	fileA, err := w.openAcquired(a)
+	if err != nil {
+		w.releaseFiles(1)
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := w.openAcquired(b)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(fileA, bufA)
+		m, errB := io.ReadFull(fileB, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package dirhash
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// duplicateTree makes a tree of many files sharing only a few distinct contents, given the
+// number of files and the size of each, including files which share a content key but differ
+// after their first dedupPrefixSize bytes.
+func duplicateTree(t testing.TB, count, size int) string {
+	files := make(map[string]string)
+	for i := 0; i < count; i++ {
+		contents := strings.Repeat(fmt.Sprint(i%4), size)
+		if i%8 == 7 {
+			contents = contents[:size-1] + "x"
+		}
+		files[fmt.Sprintf("dir%d/file%03d", i%10, i)] = contents
+	}
+	return makeTree(t, files)
+}
+
+func TestDedupContent(t *testing.T) {
+	root := duplicateTree(t, 64, 2*dedupPrefixSize)
+	want, err := HashDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := HashDirWithOptions(root, Options{DedupContent: true, FileConcurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("hash under DedupContent is %X, want %X", got, want)
+	}
+}
+
+func BenchmarkDedupContent(b *testing.B) {
+	const count, size = 256, 256 << 10
+	root := duplicateTree(b, count, size)
+	for _, dedup := range []bool{false, true} {
+		b.Run(fmt.Sprintf("dedup=%t", dedup), func(b *testing.B) {
+			b.SetBytes(count * size)
+			for i := 0; i < b.N; i++ {
+				if _, err := HashDirWithOptions(root, Options{DedupContent: dedup}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
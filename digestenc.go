@@ -0,0 +1,72 @@
+package dirhash
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Encoding says how a hash is written out as text.
+type Encoding int
+
+const (
+	// EncodingHex is capitalized hexadecimal, the way HashDir's pseudo-files write hashes.
+	EncodingHex Encoding = iota
+
+	// EncodingLowerHex is lowercase hexadecimal, as sha256sum and most other tools write hashes.
+	EncodingLowerHex
+
+	// EncodingBase64 is standard base64, with padding.
+	EncodingBase64
+
+	// EncodingBase64URL is the URL and filename safe variant of base64, without padding.
+	EncodingBase64URL
+
+	// EncodingBase32 is lowercase base32 in the standard alphabet, without padding, which is
+	// safe in URLs, filenames, and case-insensitive places such as DNS names.
+	EncodingBase32
+)
+
+var encodingNames = map[Encoding]string{
+	EncodingHex:       "hex",
+	EncodingLowerHex:  "lowerhex",
+	EncodingBase64:    "base64",
+	EncodingBase64URL: "base64url",
+	EncodingBase32:    "base32",
+}
+
+// ParseEncoding returns the encoding with the given name, as returned by Encoding.String.
+func ParseEncoding(name string) (Encoding, error) {
+	for e, n := range encodingNames {
+		if n == name {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown encoding %q", name)
+}
+
+// String returns the short lowercase name of the encoding, such as "hex".
+func (e Encoding) String() string {
+	if name, ok := encodingNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// EncodeToString writes sum as text in this encoding.
+func (e Encoding) EncodeToString(sum []byte) string {
+	switch e {
+	case EncodingLowerHex:
+		return hex.EncodeToString(sum)
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(sum)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(sum)
+	case EncodingBase32:
+		return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum))
+	default:
+		return strings.ToUpper(hex.EncodeToString(sum))
+	}
+}
@@ -32,20 +32,630 @@
 package dirhash
 
 import (
+	"archive/tar"
+	"bufio"
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // HashDir performs the directory hashing algorithm described previously.
 func HashDir(path string) ([]byte, error) {
+	return HashDirWithOptions(path, Options{})
+}
+
+// HashDirContext is like HashDir, but gives up once ctx is done, returning ctx.Err(). Both the
+// traversal and the reading of each file stop promptly, so a hash on behalf of a request which
+// has been cancelled doesn't carry on to completion.
+func HashDirContext(ctx context.Context, path string) ([]byte, error) {
+	return HashDirWithOptionsContext(ctx, path, Options{})
+}
+
+// HashDirWithOptions performs the directory hashing algorithm, tuned by opts. Every behavior
+// which can be adjusted is controlled through Options, so that new knobs never need to change
+// this signature, and the zero Options gives exactly the same result as HashDir.
+//
+// Whenever an error comes of a particular file or directory, it is an *os.PathError whose Path
+// says which entry failed and whose Op says what was being done to it, wrapping the underlying
+// cause; errors.As and errors.Is tell them apart without any parsing of messages.
+func HashDirWithOptions(path string, opts Options) ([]byte, error) {
+	return HashDirWithOptionsContext(context.Background(), path, opts)
+}
+
+// HashDirWithOptionsContext is like HashDirWithOptions, but gives up once ctx is done, in the
+// same way as HashDirContext.
+func HashDirWithOptionsContext(ctx context.Context, path string, opts Options) ([]byte, error) {
+	w := newWalker(&opts)
+	w.ctx = ctx
+	return w.run(path)
+}
+
+// run hashes the tree rooted at path, doing all the setup and cleanup around the hash itself.
+func (w *walker) run(path string) ([]byte, error) {
+	w.root = path
+	if w.opts.Stats != nil {
+		*w.opts.Stats = Stats{}
+		start := time.Now()
+		defer func() { w.opts.Stats.Elapsed = time.Since(start) }()
+	}
+	defer w.startRing()()
+	hash, err := w.runHash(path)
+	if err != nil {
+		return nil, w.relativeError(err)
+	}
+	return hash, w.skippedError()
+}
+
+func (w *walker) runHash(path string) ([]byte, error) {
+	if err := w.checkPatterns(); err != nil {
+		return nil, err
+	}
+	if err := w.checkRoot(path); err != nil {
+		return nil, err
+	}
+	if err := w.findRootDevice(path); err != nil {
+		return nil, err
+	}
+	if !w.opts.ShellSortCompat && !w.opts.ContentOnly {
+		if err := w.startCheckpoint(path); err != nil {
+			return nil, err
+		}
+	}
+	if w.opts.Metadata&MetadataLinks != 0 && !w.opts.ShellSortCompat && !w.opts.ContentOnly {
+		if err := w.findHardlinks(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Reporting an ETA needs to know how much there is to do before starting
+	if w.opts.ProgressWithETA != nil {
+		var files, total int64
+		if err := w.estimate(path, &files, &total); err != nil {
+			return nil, err
+		}
+		w.eta = newETATracker(total, w.opts.ProgressWithETA)
+	}
+	if w.opts.Progress != nil {
+		w.progress = &progressTracker{callback: w.opts.Progress}
+	}
+
+	var hash []byte
+	var err error
+	if w.opts.ShellSortCompat {
+		hash, err = w.hashShellSorted(path)
+	} else if w.opts.ContentOnly {
+		hash, err = w.hashContentOnly(path)
+	} else {
+		hash, err = w.hashTree(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w.eta != nil {
+		w.eta.finish()
+	}
+	return hash, nil
+}
+
+// walker holds the state shared by every directory visited while hashing a single tree.
+type walker struct {
+	ctx       context.Context
+	opts      *Options
+	root      string           // The directory being hashed, for RelativeErrors
+	dirSlots  chan struct{}    // Tokens for the extra goroutines allowed to enumerate directories
+	fileSlots chan struct{}    // Tokens for the extra goroutines allowed to hash files
+	dedup     *contentCache    // Recently seen file contents, if DedupContent is set
+	eta       *etaTracker      // Progress towards the estimated total, if ProgressWithETA is set
+	progress  *progressTracker // Running totals, if Progress is set
+	copyTo    string           // Where the tree is being copied to, for HashAndCopy
+	packTo    *tar.Writer      // Where the tree is being archived to, for Pack
+	fsys      fs.FS            // The filesystem holding the tree, for HashFS, or nil for the disk
+	ignores   ignoreCache      // The rules from ignore files in each directory, for IgnoreFiles
+	files     *fileBudget      // The files which may still be opened, if MaxOpenFiles is set
+	skipped   skippedEntries   // The entries skipped so far, under ErrorCollect
+	rootDev   uint64           // The device holding the root, if haveRootDev is set
+	hardlinks map[inode]string // The first path of every file with several links, for MetadataLinks
+	linked    linkedHashes     // The hashes of files with several links, once each is read
+	chunks    sync.Map         // The chunk hashes of each file read in several chunks, under ChunkSize
+
+	// appleDoubles holds the path of the AppleDouble file found for each entry under
+	// AppleDoubleMerge, until the entry is hashed.
+	appleDoubles sync.Map
+
+	// proofDirs holds the directories whose pseudo-files are wanted for a Prove, each of which
+	// is written into its builder as it is hashed.
+	proofDirs map[string]*strings.Builder
+
+	// ring is what files are read through under IOUring, while a hash is running and the ring
+	// could be set up.
+	ring atomic.Pointer[uring]
+
+	// skippedAny is set once anything has been skipped under OnError, after which nothing more
+	// is recorded in any Checkpoint.
+	skippedAny atomic.Bool
+
+	// haveRootDev is set under OneFileSystem, once the device holding the root is known.
+	haveRootDev bool
+
+	// shape replaces the hash of each file with its size in decimal, for ShapeHash.
+	shape bool
+
+	// onDir, if set, is called with each directory once it has been hashed. Returning an error
+	// aborts the hash, and that error is returned to the caller.
+	onDir func(path string, hash []byte) error
+
+	// onFile, if set, is called with each file once it has been hashed, possibly concurrently,
+	// along with its size and whether it was a symbolic link hashed by its target. Returning an
+	// error aborts the hash, as for onDir.
+	onFile func(path string, size int64, link bool, hash []byte) error
+
+	// compare, if set, has every file and directory hashed under two algorithms at once, for
+	// CompareAlgorithms.
+	compare *comparison
+}
+
+func newWalker(opts *Options) *walker {
+	if opts.ShellSortCompat {
+		// The shell-compatible listing has a fixed format which other format options don't touch
+		opts.DomainSeparateNodes = false
+		opts.ChunkSize = 0
+		opts.Algorithm = AlgorithmSHA256
+	}
+
+	w := &walker{ctx: context.Background(), opts: opts}
+	w.ignores.rules = make(map[string]*ignoreRules)
+	if opts.DirConcurrency > 1 {
+		w.dirSlots = make(chan struct{}, opts.DirConcurrency-1)
+	}
+	if opts.FileConcurrency > 1 {
+		w.fileSlots = make(chan struct{}, opts.FileConcurrency-1)
+	}
+	if opts.DedupContent && opts.Transform == nil {
+		w.dedup = newContentCache()
+	}
+	if opts.MaxOpenFiles > 0 {
+		w.files = newFileBudget(opts.MaxOpenFiles)
+	}
+	return w
+}
+
+// spawn runs fn on a new goroutine if one of the given slots is free, or else runs it
+// immediately, in which case it reports true. A nil slots channel always runs fn immediately.
+func (w *walker) spawn(slots chan struct{}, wg *sync.WaitGroup, fn func()) bool {
+	if w.trySpawn(slots, wg, fn) {
+		return false
+	}
+	fn()
+	return true
+}
+
+// trySpawn runs fn on a new goroutine if one of the given slots is free, reporting whether it
+// did. A nil slots channel never has one free.
+func (w *walker) trySpawn(slots chan struct{}, wg *sync.WaitGroup, fn func()) bool {
+	select {
+	case slots <- struct{}{}:
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			fn()
+		}()
+		return true
+	default:
+		return false
+	}
+}
+
+// hashTree hashes the directory at path, together with everything beneath it.
+func (w *walker) hashTree(path string) ([]byte, error) {
+	if !w.opts.StableOutput {
+		return w.hashDir(path, 0, nil)
+	}
+
+	events := new(eventLog)
+	hash, err := w.hashDir(path, 0, events)
+	if err != nil {
+		return nil, err
+	}
+	events.replay()
+	return hash, nil
+}
+
+// dirEntry is a single line of the pseudo-file describing a directory.
+type dirEntry struct {
+	name  string
+	dir   bool
+	sum   []byte // The hash of a subdirectory, or of a file which was read
+	hash  string // The hash, or whatever stands in for it, exactly as written in the line
+	attrs string // Any attributes written after the name
+	link  bool   // A symbolic link to be hashed by its target, under SymlinkHashTarget
+}
+
+// structurePlaceholder stands in for the hash of every file under StructureOnly. Being no valid
+// hash or size, it keeps such pseudo-files distinct from any others.
+const structurePlaceholder = "-"
+
+// presentPlaceholder stands in for the hash of every directory which is left unread, beyond
+// MaxDepth or on another filesystem under OneFileSystem, saying only that it is there.
+const presentPlaceholder = "+"
+
+// xattr is a single extended attribute of a file.
+type xattr struct {
+	name  string
+	value []byte
+}
+
+// hashDir hashes the directory at path, which is depth directories below the root. Any
+// callbacks are buffered in events, if it isn't nil.
+//
+// Each directory's hash depends on those of everything in it, so rather than recursing, the
+// directories on the way down are kept on a stack of their own, and each is finished once the
+// last of its entries has been hashed. However deep the tree goes, the walk takes no more of the
+// goroutine's stack, and a pathological tree costs only the memory for its listings. Directories
+// handed off to goroutines of their own are each hashed from a fresh stack, in the same way.
+func (w *walker) hashDir(path string, depth int, events *eventLog) ([]byte, error) {
+	root, sum, err := w.openDir(path, depth, events)
+	if root == nil {
+		return sum, err
+	}
+	root.sum, root.err = &sum, &err
+
+	stack := []*dirFrame{root}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		if f.next == len(f.contents) {
+			// Everything in the directory has been hashed or handed off, so it can be finished
+			f.wg.Wait()
+			stack = stack[:len(stack)-1]
+			*f.sum, *f.err = w.finishDir(f)
+			if len(stack) > 0 && *f.err != nil && !w.skippable(*f.err) {
+				abandon(stack)
+				return nil, *f.err
+			}
+			continue
+		}
+
+		// Hash the next entry, handing subdirectories and files off to other goroutines when
+		// there are any to spare. Each entry is filled in at its own index, so the listing stays
+		// in order of name whatever gets done first.
+		i := f.next
+		f.next++
+		if err := w.ctx.Err(); err != nil {
+			abandon(stack)
+			return nil, err
+		}
+		x := f.contents[i]
+		entry := &f.entries[i]
+		entry.name = x.Name()
+		entry.dir = x.IsDir()
+		entry.link = x.Type()&fs.ModeSymlink != 0 && w.opts.Symlinks == SymlinkHashTarget
+
+		var inline bool
+		if entry.dir {
+			entryPath := w.join(f.path, entry.name)
+			subEvents := f.events.child()
+			inline = !w.trySpawn(w.dirSlots, &f.wg, func() {
+				f.errs[i] = w.hashSubdir(entryPath, f.depth, x, entry, subEvents)
+			})
+			if inline {
+				descend, err := w.prepareSubdir(entryPath, f.depth, x, entry)
+				if err == nil && descend {
+					var sub *dirFrame
+					sub, entry.sum, err = w.openDir(entryPath, f.depth+1, subEvents)
+					if sub != nil {
+						sub.sum, sub.err = &entry.sum, &f.errs[i]
+						stack = append(stack, sub)
+						continue
+					}
+				}
+				f.errs[i] = err
+			}
+		} else {
+			slots, work := w.entryWork(f.path, x, entry, f.events)
+			inline = w.spawn(slots, &f.wg, func() { f.errs[i] = work() })
+		}
+		if inline && f.errs[i] != nil && !w.skippable(f.errs[i]) {
+			abandon(stack)
+			return nil, f.errs[i]
+		}
+	}
+	return sum, err
+}
+
+// dirFrame is a directory on the stack kept by hashDir, whose entries are being hashed in turn.
+type dirFrame struct {
+	path     string
+	depth    int
+	events   *eventLog
+	contents []fs.DirEntry
+	entries  []dirEntry
+	errs     []error
+	next     int            // The index of the next entry to be hashed
+	wg       sync.WaitGroup // The entries being hashed on goroutines of their own
+	sum      *[]byte        // Where the directory's hash goes once it is finished
+	err      *error         // Where the error goes instead, if it fails
+}
+
+// abandon waits for everything being hashed on other goroutines within the directories on the
+// stack, once the hash has failed.
+func abandon(stack []*dirFrame) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		stack[i].wg.Wait()
+	}
+}
+
+// openDir lists the directory at path, which is depth directories below the root, returning it
+// ready for its entries to be hashed. If its hash can be restored from a Checkpoint instead,
+// there is nothing to be done, and the hash is returned without a frame.
+func (w *walker) openDir(path string, depth int, events *eventLog) (*dirFrame, []byte, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := w.checkNesting(path, depth); err != nil {
+		return nil, nil, err
+	}
+	if hash, ok := w.restoreDir(path); ok {
+		return nil, hash, nil
+	}
+
+	contents, err := w.listDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := w.outputDir(path); err != nil {
+		return nil, nil, err
+	}
+	w.reportListed(path, contents)
+	w.countDir()
+	return &dirFrame{
+		path:     path,
+		depth:    depth,
+		events:   events,
+		contents: contents,
+		entries:  make([]dirEntry, len(contents)),
+		errs:     make([]error, len(contents)),
+	}, nil, nil
+}
+
+// finishDir hashes the directory in f, once everything in it has been hashed.
+func (w *walker) finishDir(f *dirFrame) ([]byte, error) {
+	path, entries := f.path, f.entries
+	var numDirs int
+	for i := range entries {
+		if f.errs[i] != nil {
+			if !w.skippable(f.errs[i]) {
+				return nil, f.errs[i]
+			}
+			w.skip(w.join(path, entries[i].name), f.errs[i], f.events)
+			entries[i].hash, entries[i].attrs, entries[i].sum = skippedPlaceholder, "", nil
+			continue
+		}
+		if entries[i].dir && entries[i].hash != presentPlaceholder {
+			entries[i].hash = fmt.Sprintf("%X", entries[i].sum)
+			numDirs++
+		}
+	}
+
+	// The root may have its own modification time recorded at the very top of its pseudo-file,
+	// after only the version line saying which metadata is recorded, if any is
+	var header = w.metadataHeader()
+	if w.opts.IncludeRootMtime && path == w.root {
+		info, err := w.stat(path)
+		if err != nil {
+			return nil, err
+		}
+		mtime := info.ModTime()
+		header += fmt.Sprintf("mtime=%d.%09d\n", mtime.Unix(), mtime.Nanosecond())
+	}
+
+	// A directory holding nothing but a single subdirectory may stand in for that subdirectory
+	if w.opts.CollapseChains && numDirs == 1 && len(entries) == 1 && header == "" {
+		if w.onDir != nil {
+			if err := w.onDir(path, entries[0].sum); err != nil {
+				return nil, err
+			}
+		}
+		return entries[0].sum, w.checkpointDir(path, entries[0].sum)
+	}
+
+	// Only tracing at the debug level and proving a file beneath need a copy of the pseudo-file
+	// as well as its hash
+	var pseudoFile *strings.Builder
+	var hash []byte
+	if w.compare != nil {
+		var err error
+		if hash, err = w.compare.hashPseudoFiles(w, header, entries); err != nil {
+			return nil, err
+		}
+	} else {
+		if w.logEnabled(slog.LevelDebug) {
+			pseudoFile = new(strings.Builder)
+		}
+		proofDir := w.proofDirs[path]
+		hash = w.hashPseudoFile(func(out io.Writer) {
+			if pseudoFile != nil {
+				out = io.MultiWriter(out, pseudoFile)
+			}
+			if proofDir != nil {
+				out = io.MultiWriter(out, proofDir)
+			}
+			io.WriteString(out, header)
+			writeEntries(out, entries)
+		})
+	}
+	w.logDir(path, hash, pseudoFile)
+
+	if w.onDir != nil {
+		if err := w.onDir(path, hash); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.checkpointDir(path, hash); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// outputDir creates the directory at path within any copy or archive being made of the tree.
+func (w *walker) outputDir(path string) error {
+	if w.copyTo != "" {
+		if err := w.makeCopyDir(path); err != nil {
+			return err
+		}
+	}
+	if w.packTo != nil {
+		return w.packDir(path)
+	}
+	return nil
+}
+
+// listDir lists the contents of the directory at path which are to be hashed, leaving out any
+// which the options exclude.
+func (w *walker) listDir(path string) ([]fs.DirEntry, error) {
+	ignores, err := w.ignoreRules(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.readDir(path, func(x fs.DirEntry) (fs.DirEntry, error) {
+		if w.opts.SkipSystemDirs && x.IsDir() && systemDirs[x.Name()] {
+			return nil, nil
+		}
+		if w.leaveOutAppleDouble(path, x.Name(), x.IsDir()) {
+			return nil, nil
+		}
+		if w.filtered(w.join(path, x.Name()), x.IsDir()) {
+			return nil, nil
+		}
+		if ignores.ignored(strings.TrimPrefix(w.join(path, x.Name()), w.root+"/"), x.IsDir()) {
+			return nil, nil
+		}
+		if x.Type()&fs.ModeSymlink != 0 {
+			switch w.opts.Symlinks {
+			case SymlinkSkip:
+				return nil, nil
+			case SymlinkReject:
+				return nil, &os.PathError{Op: "open", Path: w.join(path, x.Name()), Err: ErrSymlink}
+			case SymlinkFollowAll:
+				info, err := w.followLink(w.join(path, x.Name()))
+				if err != nil {
+					return nil, err
+				}
+				x = fs.FileInfoToDirEntry(info)
+			}
+		}
+		return w.checkSpecial(w.join(path, x.Name()), x)
+	})
+}
+
+// checkRoot applies the symlink policy to the root itself, which is always opened by path and
+// so would otherwise silently be followed if it were a link. Since the root can't be left out,
+// SymlinkSkip refuses a link there just like SymlinkReject, while the modes which hash links
+// one way or another simply follow it.
+func (w *walker) checkRoot(path string) error {
+	if w.opts.Symlinks != SymlinkSkip && w.opts.Symlinks != SymlinkReject {
+		return nil
+	}
+	info, err := w.lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return &os.PathError{Op: "open", Path: path, Err: ErrSymlink}
+	}
+	return nil
+}
+
+// ErrTooDeep is the underlying error when directories are nested deeper than NestingLimit.
+var ErrTooDeep = errors.New("directories nested too deeply")
+
+// checkNesting fails if the directory at path, depth directories below the root, is any deeper
+// than NestingLimit allows.
+func (w *walker) checkNesting(path string, depth int) error {
+	if w.opts.NestingLimit > 0 && depth > w.opts.NestingLimit {
+		return &os.PathError{Op: "open", Path: path, Err: ErrTooDeep}
+	}
+	return nil
+}
+
+// beyondMaxDepth reports whether a directory depth directories below the root is too deep to
+// be listed under MaxDepth.
+func (w *walker) beyondMaxDepth(depth int) bool {
+	return w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth
+}
+
+// findRootDevice records the device holding the root at path, under OneFileSystem.
+func (w *walker) findRootDevice(path string) error {
+	if !w.opts.OneFileSystem {
+		return nil
+	}
+	info, err := w.stat(path)
+	if err != nil {
+		return err
+	}
+	w.rootDev, _, w.haveRootDev = fileID(info)
+	return nil
+}
+
+// otherFilesystem reports whether the directory described by info is on a different device
+// from the root, under OneFileSystem. It never is where there are no device numbers to compare.
+func (w *walker) otherFilesystem(info os.FileInfo) bool {
+	if !w.haveRootDev || info == nil {
+		return false
+	}
+	dev, _, ok := fileID(info)
+	return ok && dev != w.rootDev
+}
+
+// descends reports whether the walk should list the directory x, depth directories below the
+// root, rather than stopping at it under MaxDepth or OneFileSystem.
+func (w *walker) descends(x fs.DirEntry, depth int) (bool, error) {
+	if w.beyondMaxDepth(depth) {
+		return false, nil
+	}
+	if !w.haveRootDev {
+		return true, nil
+	}
+	info, err := x.Info()
+	if err != nil {
+		return false, err
+	}
+	return !w.otherFilesystem(info), nil
+}
+
+// systemDirs are the directories left out by SkipSystemDirs.
+var systemDirs = map[string]bool{
+	"lost+found":                true,
+	".Trashes":                  true,
+	"$RECYCLE.BIN":              true,
+	"System Volume Information": true,
+}
+
+// dirBatchSize is how many entries readDir reads from a directory at a time.
+const dirBatchSize = 1024
+
+// readDir lists the contents of the directory at path in order of name. Only the names and types
+// of the entries are read, leaving the rest of their information to be looked up as and when it
+// is needed, which for most directories is never. Entries are read a batch at a time, and if
+// keep isn't nil, each is passed to it as soon as it is read, and replaced by what it returns
+// or dropped if that is nil; this way entries which are left out never pile up in memory, even
+// in directories holding millions. The directory is closed again before returning, so that it
+// isn't held open while its subdirectories are being hashed.
+func (w *walker) readDir(path string, keep func(fs.DirEntry) (fs.DirEntry, error)) ([]fs.DirEntry, error) {
 	// Open whatever's at the given path
-	file, err := os.Open(path)
+	file, err := w.open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -58,88 +668,507 @@ func HashDir(path string) ([]byte, error) {
 	}
 
 	// Error out if it isn't a directory
-	if !info.IsDir() {
-		return nil, errors.New("not a directory")
+	dir, ok := file.(fs.ReadDirFile)
+	if !info.IsDir() || !ok {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	// Read the directory contents in batches, keeping only what's wanted from each
+	var contents []fs.DirEntry
+	for {
+		batch, readErr := dir.ReadDir(dirBatchSize)
+		for _, x := range batch {
+			if keep != nil {
+				if x, err = keep(x); err != nil {
+					return nil, err
+				}
+			}
+			if x != nil {
+				contents = append(contents, x)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, diskError(readErr, path)
+		}
 	}
+	sort.Slice(contents, func(i, j int) bool { return contents[i].Name() < contents[j].Name() })
+	return contents, nil
+}
 
-	// Get the full list of directory contents
-	contents, err := file.Readdir(0)
+// fileAttributes returns the extra attributes, if any, which are recorded after a file's name
+// in its pseudo-file line. Each takes the form " key=value".
+func (w *walker) fileAttributes(path string, info os.FileInfo) (string, error) {
+	attrs, err := w.dirAttributes(path, info)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	attrs += w.linkAttributes(path, info)
+	if w.opts.IncludeCapabilities && !w.opts.IncludeXattrs && w.fsys == nil {
+		capability, err := getCapability(path)
+		if err != nil {
+			return "", err
+		}
+		if capability != nil {
+			attrs += fmt.Sprintf(" capability=%X", capability)
+		}
+	}
+	return attrs, nil
+}
+
+// dirAttributes returns the extra attributes which are recorded after the name of every entry,
+// whether file or directory.
+func (w *walker) dirAttributes(path string, info os.FileInfo) (string, error) {
+	var attrs = w.metadataAttributes(info)
+	if w.opts.IncludeXattrs && w.fsys == nil {
+		xattrs, err := listXattrs(path)
+		if err != nil {
+			return "", err
+		}
+		for _, x := range xattrs {
+			attrs += fmt.Sprintf(" xattr=\"%s\":%X", escape(x.name), x.value)
+		}
+	}
+	if w.opts.IncludeStreams && w.fsys == nil {
+		streams, err := w.streamAttributes(path)
+		if err != nil {
+			return "", err
+		}
+		attrs += streams
 	}
+	if w.opts.AppleDouble == AppleDoubleMerge {
+		apple, err := w.appleAttributes(path)
+		if err != nil {
+			return "", err
+		}
+		attrs += apple
+	}
+	return attrs, nil
+}
 
-	// Iterate over the contents of the directory accumulating hashes recursively
-	var dirs = make(map[string]string)
-	var files = make(map[string]string)
-	for _, x := range contents {
-		if x.IsDir() {
-			hash, err := HashDir(path + "/" + x.Name())
+// hashEntry fills in the hash and attributes of the file at path, as listed in its directory.
+func (w *walker) hashEntry(path string, x fs.DirEntry, entry *dirEntry, events *eventLog) error {
+	info, err := x.Info()
+	if err != nil {
+		return err
+	}
+
+	// A link which is followed is hashed by the file it points to, so that file is what its
+	// size, and any cached or hard-linked hash, ought to come from
+	hashed := info
+	if x.Type()&fs.ModeSymlink != 0 && !entry.link {
+		if hashed, err = w.stat(path); err != nil {
+			return err
+		}
+	}
+	hash, err := w.hashFile(path, hashed, entry.link, events)
+	if err != nil {
+		return err
+	}
+	entry.sum, entry.hash = hash, fmt.Sprintf("%X", hash)
+	if entry.link {
+		return nil
+	}
+
+	entry.attrs, err = w.fileAttributes(path, info)
+	return err
+}
+
+// prepareSubdir fills in the attributes of entry, which lists the subdirectory x at path within
+// a directory depth directories below the root, and reports whether the subdirectory is to be
+// hashed in turn. One which is left unread gets a placeholder for its hash instead.
+func (w *walker) prepareSubdir(path string, depth int, x fs.DirEntry, entry *dirEntry) (bool, error) {
+	// Only metadata, and telling a mount point, need anything more of a directory than its name
+	var info os.FileInfo
+	var err error
+	if w.opts.Metadata != 0 || w.opts.OneFileSystem {
+		if info, err = x.Info(); err != nil {
+			return false, err
+		}
+	}
+	if entry.attrs, err = w.dirAttributes(path, info); err != nil {
+		return false, err
+	}
+	if w.beyondMaxDepth(depth+1) || w.otherFilesystem(info) {
+		// Any copy or archive still has the directory, only empty
+		entry.hash = presentPlaceholder
+		return false, w.outputDir(path)
+	}
+	return true, nil
+}
+
+// hashSubdir fills in entry, which lists the subdirectory x at path within a directory depth
+// directories below the root, hashing the subdirectory and everything beneath it.
+func (w *walker) hashSubdir(path string, depth int, x fs.DirEntry, entry *dirEntry, events *eventLog) error {
+	descend, err := w.prepareSubdir(path, depth, x, entry)
+	if err != nil || !descend {
+		return err
+	}
+	entry.sum, err = w.hashDir(path, depth+1, events)
+	return err
+}
+
+// entryWork returns the work of filling in entry, which lists the file x in the directory at
+// path, and the slots for the extra goroutines which that work may be handed off to.
+func (w *walker) entryWork(path string, x fs.DirEntry, entry *dirEntry, events *eventLog) (chan struct{}, func() error) {
+	entryPath := w.join(path, entry.name)
+	switch {
+	case w.opts.SpecialFiles == SpecialRecord && specialMarker(x.Type()) != "":
+		// A special file is only marked as the kind of file it is, along with its metadata
+		return nil, func() error {
+			entry.hash = specialMarker(x.Type())
+			info, err := x.Info()
 			if err != nil {
-				return nil, err
+				return err
 			}
-			dirs[x.Name()] = fmt.Sprintf("%X", hash)
-		} else {
-			hash, err := HashFile(path + "/" + x.Name())
+			entry.attrs, err = w.fileAttributes(entryPath, info)
+			return err
+		}
+
+	case w.shape:
+		// When only the shape of the tree matters, a file's size stands in for its hash
+		return nil, func() error {
+			info, err := x.Info()
 			if err != nil {
-				return nil, err
+				return err
+			}
+			entry.hash = fmt.Sprintf("%d", info.Size())
+			return nil
+		}
+
+	case w.opts.StructureOnly:
+		// When only the structure matters, every file gets the same placeholder instead
+		return nil, func() error {
+			entry.hash = structurePlaceholder
+			if entry.link {
+				return nil
+			}
+			info, err := x.Info()
+			if err != nil {
+				return err
 			}
-			files[x.Name()] = fmt.Sprintf("%X", hash)
+			entry.attrs, err = w.fileAttributes(entryPath, info)
+			return err
 		}
+
+	default:
+		fileEvents := events.child()
+		return w.fileSlots, func() error { return w.hashEntry(entryPath, x, entry, fileEvents) }
 	}
+}
 
-	// Create lists of all subdirectories and files in alphabetical order
-	var dirPaths []string
-	for k, _ := range dirs {
-		dirPaths = append(dirPaths, k)
+// writeEntries writes out the lines of the special "file" representing a directory's contents,
+// with the subdirectories and then the files each in alphabetical order.
+func writeEntries(out io.Writer, entries []dirEntry) {
+	for _, e := range entries {
+		if e.dir {
+			writeEntry(out, e)
+		}
+	}
+	io.WriteString(out, "=\n")
+	for _, e := range entries {
+		if !e.dir {
+			writeEntry(out, e)
+		}
 	}
-	sort.Strings(dirPaths)
+}
 
-	var filePaths []string
-	for k, _ := range files {
-		filePaths = append(filePaths, k)
+// writeEntry writes the line of a directory's pseudo-file which lists e.
+func writeEntry(out io.Writer, e dirEntry) {
+	io.WriteString(out, e.hash)
+	io.WriteString(out, " \"")
+	io.WriteString(out, escape(e.name))
+	io.WriteString(out, "\"")
+	io.WriteString(out, e.attrs)
+	io.WriteString(out, "\n")
+}
+
+// hashPseudoFile hashes the pseudo-file representing a directory, which write writes to out.
+// The pseudo-file goes straight into the hash as it is written, rather than being assembled in
+// memory first, so that even directories with millions of entries take little memory to hash.
+func (w *walker) hashPseudoFile(write func(out io.Writer)) []byte {
+	return w.hashPseudoFileWith(w.newHash(), write)
+}
+
+// hashPseudoFileWith is hashPseudoFile, hashing the pseudo-file with hasher.
+func (w *walker) hashPseudoFileWith(hasher hash.Hash, write func(out io.Writer)) []byte {
+	if w.opts.DomainSeparateNodes {
+		hasher.Write([]byte{nodePrefix})
 	}
-	sort.Strings(filePaths)
+	out := bufio.NewWriter(hasher)
+	write(out)
+	out.Flush()
+	return hasher.Sum(nil)
+}
 
-	// Create the special "file" representing the directory's contents
-	var pseudoFile string
-	for _, dirPath := range dirPaths {
-		pseudoFile += dirs[dirPath] + " \"" + escape(dirPath) + "\"\n"
+func escape(x string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(x)
+}
+
+// hashFile hashes a single file within the tree, as described by info, and reports it to any
+// callbacks, by way of events if it isn't nil. If link is set, the file is a symbolic link to be
+// hashed by its target.
+func (w *walker) hashFile(path string, info os.FileInfo, link bool, events *eventLog) ([]byte, error) {
+	start := time.Now()
+	size := info.Size()
+	w.progress.report(EventFileStarted, w.display(path), 0, 0, 0)
+	hash, err := w.withFileTimeout(path, func() ([]byte, error) {
+		if link {
+			return w.hashLinkTarget(path)
+		}
+		return w.hashLinked(info, func() ([]byte, error) {
+			return w.cachedHash(info, func() ([]byte, error) {
+				if w.dedup != nil {
+					return w.hashDeduplicated(path, size)
+				}
+				return w.hashContents(path)
+			})
+		})
+	})
+	if err != nil {
+		return nil, lockedError(err)
+	}
+	chunks := w.takeChunks(path)
+
+	if w.eta != nil {
+		w.eta.add(size)
+	}
+	w.countFile()
+	w.logFile(path, size, hash)
+	w.progress.report(EventFileHashed, w.display(path), 0, 1, size)
+	if d := time.Since(start); w.opts.SlowFileThreshold > 0 && d > w.opts.SlowFileThreshold && w.opts.OnSlowFile != nil {
+		events.emit(func() { w.opts.OnSlowFile(w.display(path), d) })
+	}
+	if w.onFile != nil {
+		if err := w.onFile(path, size, link, hash); err != nil {
+			return nil, err
+		}
 	}
-	pseudoFile += "=\n"
-	for _, filePath := range filePaths {
-		pseudoFile += files[filePath] + " \"" + escape(filePath) + "\"\n"
+	if w.opts.OnFile != nil {
+		events.emit(func() { w.opts.OnFile(Entry{Path: w.display(path), Size: size, Sum: hash, Chunks: chunks}) })
 	}
-	log.Printf("Hashing directory:\n\"\"\"\n%s\"\"\"\n", pseudoFile)
+	return hash, nil
+}
 
-	// Hash this special file
-	hasher := sha256.New()
-	_, err = hasher.Write([]byte(pseudoFile))
+// display returns path the way it should be shown to the user, which is relative to the root
+// under RelativeErrors.
+func (w *walker) display(path string) string {
+	if !w.opts.RelativeErrors {
+		return path
+	}
+	if path == w.root {
+		return "."
+	}
+	return strings.TrimPrefix(path, w.root+"/")
+}
+
+// relativeError rewrites the path in err to be relative to the root, under RelativeErrors.
+func (w *walker) relativeError(err error) error {
+	var pathErr *os.PathError
+	if !w.opts.RelativeErrors || !errors.As(err, &pathErr) {
+		return err
+	}
+	return &os.PathError{Op: pathErr.Op, Path: w.display(pathErr.Path), Err: pathErr.Err}
+}
+
+// ErrFileTimeout is the underlying error when a file takes longer than PerFileTimeout to hash.
+var ErrFileTimeout = errors.New("timed out reading file")
+
+// withFileTimeout runs fn, giving up on it if it takes longer than PerFileTimeout. There is no
+// way to interrupt a read of a regular file which has stalled, so in that case fn is abandoned
+// to finish in the background whenever it can.
+func (w *walker) withFileTimeout(path string, fn func() ([]byte, error)) ([]byte, error) {
+	if w.opts.PerFileTimeout <= 0 {
+		return fn()
+	}
+
+	type result struct {
+		hash []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		hash, err := fn()
+		done <- result{hash, err}
+	}()
+
+	timer := time.NewTimer(w.opts.PerFileTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.hash, r.err
+	case <-timer.C:
+		return nil, &os.PathError{Op: "read", Path: path, Err: ErrFileTimeout}
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+}
+
+// eventLog buffers callbacks under StableOutput, so that they can be delivered in the same order
+// whichever goroutines produced them. Each directory records into its own log, which holds its
+// place within the log of its parent.
+type eventLog struct {
+	events []func()
+}
+
+// emit delivers an event, or records it for later if the log isn't nil.
+func (l *eventLog) emit(event func()) {
+	if l == nil {
+		event()
+		return
+	}
+	l.events = append(l.events, event)
+}
+
+// child returns a new log whose events will be replayed at this point in the parent log.
+func (l *eventLog) child() *eventLog {
+	if l == nil {
+		return nil
+	}
+	c := new(eventLog)
+	l.emit(c.replay)
+	return c
+}
+
+// replay delivers every recorded event in order.
+func (l *eventLog) replay() {
+	for _, event := range l.events {
+		event()
+	}
+}
+
+// newHash returns a new hash of whichever kind the options call for.
+func (w *walker) newHash() hash.Hash {
+	if w.opts.NewHash != nil {
+		return w.opts.NewHash()
+	}
+	return w.opts.Algorithm.New()
+}
+
+// The prefixes which distinguish file and directory hashes under DomainSeparateNodes.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// hashContents hashes the contents of the file at path according to the options in effect.
+func (w *walker) hashContents(path string) (hash []byte, err error) {
+	file, err := w.open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	return hasher.Sum(nil), nil
+	// When copying, everything read is written straight back out to the copy as well
+	var source io.Reader = file
+	if mapped, unmap := w.mapContents(path, file); mapped != nil {
+		defer unmap()
+		source = mapped
+	} else if read, err := w.ringContents(path, file); err != nil {
+		return nil, err
+	} else if read != nil {
+		source = read
+	} else if w.ctx.Done() != nil {
+		source = contextReader{w.ctx, file}
+	}
+	source, counted := w.countRead(source)
+	defer counted()
+	if w.copyTo != "" {
+		out, err := w.createCopy(path, file)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		source = io.TeeReader(source, out)
+	}
+	if w.packTo != nil {
+		out, err := w.packFile(path, file)
+		if err != nil {
+			return nil, err
+		}
+		source = io.TeeReader(source, out)
+	}
+
+	// Let the caller make whatever changes they like to the contents on the way through
+	var contents = source
+	if w.opts.Transform != nil {
+		contents, err = w.opts.Transform(w.display(path), source)
+		if err != nil {
+			return nil, &os.PathError{Op: "transform", Path: path, Err: err}
+		}
+		if closer, ok := contents.(io.Closer); ok && contents != source {
+			defer closer.Close()
+		}
+	}
+
+	if w.opts.ChunkSize > 0 {
+		var chunks [][]byte
+		if hash, chunks, err = w.hashChunks(contents); err != nil {
+			return nil, err
+		}
+		if chunks != nil {
+			w.chunks.Store(path, chunks)
+		}
+	} else {
+		hasher := w.newHash()
+		if w.opts.DomainSeparateNodes {
+			hasher.Write([]byte{leafPrefix})
+		}
+		if _, err := io.Copy(hasher, contents); err != nil {
+			return nil, err
+		}
+		hash = hasher.Sum(nil)
+	}
+
+	// A transform might not have read the whole file, but the copy needs all of it
+	if w.copyTo != "" || w.packTo != nil {
+		if _, err := io.Copy(ioutil.Discard, source); err != nil {
+			return nil, err
+		}
+	}
+	return hash, nil
 }
 
-func escape(x string) string {
-	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(x)
+// contextReader fails every read once ctx is done, so that reading a large file can be cut
+// short rather than only being noticed once it is finished.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
 
 // HashFile ought to yield the same hash values as the unix 'sha256sum' utility.
 func HashFile(path string) ([]byte, error) {
-	// Read whatever's at the given path
-	contents, err := ioutil.ReadFile(path)
+	// Open whatever's at the given path
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	// Feed the file contents into an SHA256 hash
+	// And hash it a piece at a time, rather than reading it all into memory first
+	return HashReader(file)
+}
+
+// HashReader computes the SHA256 hash of everything read from r until EOF, in the same way as
+// HashFile, using a fixed-size buffer however much data there is.
+func HashReader(r io.Reader) ([]byte, error) {
 	hasher := sha256.New()
-	_, err = hasher.Write(contents)
-	if err != nil {
+	buf := make([]byte, 32*1024)
+	if _, err := io.CopyBuffer(hasher, r, buf); err != nil {
 		return nil, err
 	}
-
-	// And return the hash output
 	return hasher.Sum(nil), nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// auditCommand audits a directory against a hashdeep audit file, as 'hashdeep -a' does, and
+// exits with status 1 if the audit fails. Under -v or -vv, every file matched is listed as well
+// as those which weren't.
+func auditCommand(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash audit -k FILE [flags] [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var knownFile = fs.String("k", "", "the hashdeep audit file of known hashes, such as '-format hashdeep' writes")
+	fs.Parse(args)
+	if *knownFile == "" || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	file, err := os.Open(*knownFile)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	known, err := dirhash.ReadHashdeep(file)
+	file.Close()
+	if err != nil {
+		fatalf(exitError, "%s: %s", *knownFile, err)
+	}
+	audit, err := dirhash.AuditHashdeep(dir, known, opts)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+
+	if !*optFlags.quiet {
+		printAudit(audit, *optFlags.verbose || *optFlags.veryVerbose)
+	}
+	if !audit.OK() {
+		os.Exit(exitMismatch)
+	}
+}
+
+// printAudit describes each file in audit in the words hashdeep uses, followed by a summary.
+func printAudit(audit *dirhash.Audit, verbose bool) {
+	if verbose {
+		for _, p := range audit.Matched {
+			fmt.Printf("%s: Ok\n", p)
+		}
+	}
+	for _, m := range audit.Moved {
+		fmt.Printf("%s: Moved from %s\n", m.Path, m.KnownPath)
+	}
+	for _, p := range audit.New {
+		fmt.Printf("%s: No match\n", p)
+	}
+	for _, p := range audit.Missing {
+		fmt.Printf("%s: Known file not used\n", p)
+	}
+
+	result := "passed"
+	if !audit.OK() {
+		result = "failed"
+	}
+	fmt.Printf("Audit %s\n", result)
+	fmt.Printf("          Files matched: %d\n", len(audit.Matched))
+	fmt.Printf("            Files moved: %d\n", len(audit.Moved))
+	fmt.Printf("        New files found: %d\n", len(audit.New))
+	fmt.Printf("  Known files not found: %d\n", len(audit.Missing))
+}
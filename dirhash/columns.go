@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// minPathWidth is the narrowest the path column is ever squeezed, however narrow the terminal.
+const minPathWidth = 20
+
+// columnEncoder lists every file with its hash, size, and path aligned into columns for easy
+// reading by humans. Paths too long for the remaining width are either cut short with a
+// leading ellipsis, keeping their final components, or wrapped onto continuation lines.
+type columnEncoder struct {
+	width int  // The total width of a line
+	wrap  bool // Whether long paths are wrapped rather than truncated
+}
+
+func (columnEncoder) EncodesFiles() bool { return true }
+
+func (c columnEncoder) Encode(r dirhash.Result) ([]byte, error) {
+	files := append([]dirhash.Entry(nil), r.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	// Every hash is the same length, while the size column fits the largest size
+	hashWidth := 2 * r.Algorithm.DigestSize()
+	sizeWidth := 0
+	for _, f := range files {
+		if n := len(strconv.FormatInt(f.Size, 10)); n > sizeWidth {
+			sizeWidth = n
+		}
+	}
+	pathWidth := c.width - hashWidth - sizeWidth - 4
+	if pathWidth < minPathWidth {
+		pathWidth = minPathWidth
+	}
+
+	var out strings.Builder
+	indent := strings.Repeat(" ", hashWidth+sizeWidth+4)
+	for _, f := range files {
+		lines := c.fitPath(f.Path, pathWidth)
+		fmt.Fprintf(&out, "%x  %*d  %s\n", f.Sum, sizeWidth, f.Size, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(&out, "%s%s\n", indent, line)
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+// fitPath breaks path up into lines no wider than width, according to the wrapping mode.
+func (c columnEncoder) fitPath(path string, width int) []string {
+	runes := []rune(path)
+	if len(runes) <= width {
+		return []string{path}
+	}
+	if !c.wrap {
+		return []string{"…" + string(runes[len(runes)-width+1:])}
+	}
+
+	var lines []string
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(lines, string(runes))
+}
+
+// terminalWidth guesses the width of the terminal from $COLUMNS, falling back on 80.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
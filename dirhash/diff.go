@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// diffCommand lists the paths at which two directories, or two manifests, differ, and exits
+// with status 1 if there are any.
+func diffCommand(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash diff [flags] DIR1 DIR2\n       dirhash diff -manifests OLD NEW\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var manifests = fs.Bool("manifests", false, "compare two manifests written by 'dirhash manifest', rather than two directories")
+	var zero = fs.Bool("0", false, "end each path listed with a NUL rather than a newline")
+	var chunks = fs.Bool("chunks", false, "with -manifests, follow each modified file with the chunks of it which changed, if both manifests list them")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	var diff *dirhash.ManifestDiff
+	if *manifests {
+		diff, err = diffManifestFiles(fs.Arg(0), fs.Arg(1))
+	} else {
+		diff, err = dirhash.DiffDirsWithOptions(fs.Arg(0), fs.Arg(1), opts)
+	}
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+
+	if !*optFlags.quiet {
+		printDiff(diff, *zero, *chunks)
+	}
+	if !diff.OK() {
+		os.Exit(exitMismatch)
+	}
+}
+
+// diffManifestFiles compares the manifests saved in the files older and newer.
+func diffManifestFiles(older, newer string) (*dirhash.ManifestDiff, error) {
+	a, err := readManifestFile(older)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readManifestFile(newer)
+	if err != nil {
+		return nil, err
+	}
+	return dirhash.DiffManifests(a, b)
+}
+
+func readManifestFile(path string) (*dirhash.Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return dirhash.ReadManifest(file)
+}
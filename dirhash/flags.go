@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// optionFlags are the flags controlling how a tree is hashed, which every command that hashes
+// one accepts.
+type optionFlags struct {
+	algo        *string
+	dirjobs     *int
+	jobs        *int
+	symlinks    *string
+	special     *string
+	include     patternList
+	exclude     patternList
+	ignoreFiles patternList
+	cache       *string
+	progress    *bool
+	metadata    *string
+	xattrs      *bool
+	streams     *bool
+	appleDouble *string
+	structure   *bool
+	content     *bool
+	nesting     *int
+	maxDepth    *int
+	oneFS       *bool
+	maxOpen     *int
+	onError     *string
+	onLocked    *string
+	timeout     *time.Duration
+	retries     *int
+	retryDelay  *time.Duration
+	chunkSize   *int64
+	mmap        *int64
+	ioUring     *bool
+	quiet       *bool
+	verbose     *bool
+	veryVerbose *bool
+}
+
+// addOptionFlags registers the hashing flags with fs.
+func addOptionFlags(fs *flag.FlagSet) *optionFlags {
+	f := new(optionFlags)
+	f.algo = fs.String("algo", "sha256", "the hash algorithm to use: sha256, sha512, sha3-256, blake3, or xxh64 for speed when security doesn't matter")
+	f.dirjobs = fs.Int("dirjobs", 1, "the number of directories to enumerate in parallel")
+	f.jobs = fs.Int("jobs", 1, "the number of files to hash in parallel")
+	f.symlinks = fs.String("symlinks", "follow", "how to treat symbolic links: follow links to files, follow-all links including directories, skip them, reject them, or hash their target paths")
+	f.special = fs.String("special-files", "read", "how to treat named pipes, sockets, and devices: read them like files, skip them, record them by their kind without reading them, or reject them")
+	fs.Var(&f.include, "include", "hash only files matching this glob pattern (may be repeated)")
+	fs.Var(&f.exclude, "exclude", "leave out files and directories matching this glob pattern, like 'node_modules' or '*.o' (may be repeated)")
+	fs.Var(&f.ignoreFiles, "ignore-file", "read gitignore-style rules from files with this name in every directory, such as .dirhashignore or .gitignore (may be repeated)")
+	f.cache = fs.String("cache", "", "remember file hashes in this file, and trust them while a file's inode, size, and mtime are unchanged")
+	f.progress = fs.Bool("progress", false, "show the files and bytes hashed so far, the throughput, and an ETA on standard error, if it is a terminal")
+	f.metadata = fs.String("metadata", "", "also hash this comma-separated metadata of every file and directory: mode, owner, mtime, links (which files are hard links to each other)")
+	f.xattrs = fs.Bool("xattrs", false, "also hash the extended attributes of every file and directory, such as security labels and capabilities")
+	f.streams = fs.Bool("streams", false, "also hash the alternate data streams of every file and directory on NTFS, where anything could be hidden alongside a file")
+	f.appleDouble = fs.String("appledouble", "keep", "what to do about macOS resource forks and the ._ AppleDouble files holding them off APFS: keep ._ files like any other, ignore them, or merge them with the forks macOS keeps itself, so copies hash the same anywhere")
+	f.structure = fs.Bool("structure-only", false, "hash only the names and layout of the tree, without reading any file")
+	f.content = fs.Bool("content-only", false, "hash only the contents of the files, ignoring their names and layout")
+	f.maxOpen = fs.Int("max-open-files", 0, "the most files and directories to hold open at once, or 0 for no limit besides -dirjobs and -jobs")
+	f.onError = fs.String("on-error", "fail", "what to do about unreadable files and directories: fail at once, skip them with a warning, or collect them, warning about each and failing once the hash is printed")
+	f.onLocked = fs.String("on-locked", "error", "what to do about files locked by another process on Windows: treat them as any other error under -on-error, always skip them with a warning, or always fail")
+	f.timeout = fs.Duration("file-timeout", 0, "give up on any file which takes longer than this to read, such as one on a hung mount, failing or skipping it according to -on-error; 0 for no limit")
+	f.retries = fs.Int("read-retries", 0, "retry opening or reading a file this many times when it fails in a way which may be transient, as on a flaky network filesystem")
+	f.retryDelay = fs.Duration("read-retry-delay", 100*time.Millisecond, "how long to wait before the first of the -read-retries, doubling each time after")
+	f.quiet = fs.Bool("q", false, "print nothing but the results, without warnings, progress, or the outcome of -expect, leaving the exit status to tell")
+	f.verbose = fs.Bool("v", false, "log each directory and its hash to standard error as it is hashed")
+	f.veryVerbose = fs.Bool("vv", false, "trace every file and directory on standard error as it is hashed, along with the pseudo-file of each directory")
+	f.maxDepth = fs.Int("max-depth", 0, "hash only this many directories deep, marking the directories at that depth as present without reading them, or 0 for the whole tree")
+	f.oneFS = fs.Bool("one-file-system", false, "don't descend into directories on other filesystems than the root, such as /proc, marking them as present without reading them")
+	f.chunkSize = fs.Int64("chunk-size", 0, "hash files larger than this many bytes in chunks of this size, listing the hash of every chunk in manifests so that huge files can be verified a chunk at a time; 0 to hash every file whole")
+	f.mmap = fs.Int64("mmap-threshold", 0, "memory-map files at least this many bytes long rather than reading them, which can be faster on a local SSD; 0 never to")
+	f.ioUring = fs.Bool("io-uring", false, "experimental: read small files through io_uring on Linux, which can be much faster for trees of many tiny files with a high -jobs")
+	f.nesting = fs.Int("nesting-limit", 0, "fail if directories are nested more than this many deep, or 0 for no limit")
+	return f
+}
+
+// options returns the Options described by the flags, once they have been parsed.
+func (f *optionFlags) options() (dirhash.Options, error) {
+	algorithm, err := dirhash.ParseAlgorithm(*f.algo)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	symlinkMode, err := dirhash.ParseSymlinkMode(*f.symlinks)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	specialMode, err := dirhash.ParseSpecialFileMode(*f.special)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	metadata, err := dirhash.ParseMetadata(*f.metadata)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	onError, err := dirhash.ParseErrorPolicy(*f.onError)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	appleDouble, err := dirhash.ParseAppleDoubleMode(*f.appleDouble)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	onLocked, err := dirhash.ParseLockedPolicy(*f.onLocked)
+	if err != nil {
+		return dirhash.Options{}, err
+	}
+	if *f.chunkSize < 0 || *f.mmap < 0 {
+		return dirhash.Options{}, fmt.Errorf("-chunk-size and -mmap-threshold can't be negative")
+	}
+	if *f.quiet && (*f.verbose || *f.veryVerbose || *f.progress) {
+		return dirhash.Options{}, fmt.Errorf("-q can't be used with -v, -vv, or -progress")
+	}
+	var cache *dirhash.HashCache
+	if *f.cache != "" {
+		if cache, err = dirhash.OpenHashCache(*f.cache); err != nil {
+			return dirhash.Options{}, err
+		}
+	}
+	opts := dirhash.Options{
+		Algorithm:       algorithm,
+		DirConcurrency:  *f.dirjobs,
+		FileConcurrency: *f.jobs,
+		Symlinks:        symlinkMode,
+		SpecialFiles:    specialMode,
+		Include:         f.include,
+		Exclude:         f.exclude,
+		IgnoreFiles:     f.ignoreFiles,
+		Metadata:        metadata,
+		IncludeXattrs:   *f.xattrs,
+		IncludeStreams:  *f.streams,
+		AppleDouble:     appleDouble,
+		StructureOnly:   *f.structure,
+		ContentOnly:     *f.content,
+		NestingLimit:    *f.nesting,
+		MaxDepth:        *f.maxDepth,
+		OneFileSystem:   *f.oneFS,
+		MaxOpenFiles:    *f.maxOpen,
+		OnError:         onError,
+		OnLocked:        onLocked,
+		PerFileTimeout:  *f.timeout,
+		ReadRetries:     *f.retries,
+		ReadRetryDelay:  *f.retryDelay,
+		ChunkSize:       *f.chunkSize,
+		MmapThreshold:   *f.mmap,
+		IOUring:         *f.ioUring,
+		Cache:           cache,
+	}
+	switch {
+	case *f.veryVerbose:
+		opts.Logger = newLogger(slog.LevelDebug)
+	case *f.verbose:
+		opts.Logger = newLogger(slog.LevelInfo)
+	}
+	if *f.progress {
+		attachProgress(&opts)
+	}
+	if (onError != dirhash.ErrorFailFast || onLocked == dirhash.LockedSkip) && opts.Logger == nil && !*f.quiet { // A logger warns of them itself
+		opts.OnSkip = func(path string, err error) {
+			fmt.Fprintf(os.Stderr, "warning: %s, skipped\n", err)
+		}
+	}
+	return opts, nil
+}
+
+// newLogger returns a logger writing messages at level and above to standard error.
+func newLogger(level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+// saveCache writes back the cache used by opts, if there is one.
+func saveCache(opts dirhash.Options) {
+	if opts.Cache == nil {
+		return
+	}
+	if err := opts.Cache.Save(); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+}
+
+// patternList collects the values of a flag which may be given any number of times.
+type patternList []string
+
+func (p *patternList) String() string     { return strings.Join(*p, ",") }
+func (p *patternList) Set(v string) error { *p = append(*p, v); return nil }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// goModCommand prints the "h1:" hash of a Go module, as the go command records it in go.sum.
+func goModCommand(args []string) {
+	fs := flag.NewFlagSet("gomod", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash gomod [-prefix MODULE@VERSION] DIR | ZIP | go.mod\n"))
+		fs.PrintDefaults()
+	}
+	var prefix = fs.String("prefix", "", "the module path and version, like golang.org/x/text@v0.14.0, which is needed to hash a directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	path := fs.Arg(0)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	var hash string
+	switch {
+	case info.IsDir():
+		if *prefix == "" {
+			fatalf(exitUsage, "hashing a module directory needs -prefix")
+		}
+		hash, err = dirhash.HashGoModule(path, *prefix)
+	case strings.HasSuffix(path, ".zip"):
+		hash, err = dirhash.HashGoModuleZip(path)
+	default:
+		hash, err = dirhash.HashGoMod(path)
+	}
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	fmt.Println(hash)
+}
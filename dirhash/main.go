@@ -1,21 +1,229 @@
 package main
 
 import (
-    "github.com/willdonnelly/dirhash"
+	"bytes"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willdonnelly/dirhash"
 )
 
+// commands are the subcommands which may be given as the first argument. Without one, the tool
+// simply prints the hash of a directory.
+var commands = map[string]func(args []string){
+	"audit":    auditCommand,
+	"diff":     diffCommand,
+	"gomod":    goModCommand,
+	"manifest": manifestCommand,
+	"pack":     packCommand,
+	"prove":    proveCommand,
+	"serve":    serveCommand,
+	"tree":     treeCommand,
+	"verify":   verifyCommand,
+	"watch":    watchCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	var hashroot = flag.String("dir", ".", "the directory to generate a cryptographic hash of")
+	var oci = flag.String("oci", "", "hash the filesystem of the container image in this OCI image layout instead of a directory")
+	var optFlags = addOptionFlags(flag.CommandLine)
+	var format = flag.String("format", "hex", "the output format, one of: "+strings.Join(dirhash.EncoderNames(), ", ")+" ('bsd' lists every file like 'shasum --tag')")
+	var encoding = flag.String("encoding", "hex", "how the hex and json formats write hashes: hex, lowerhex, base64, base64url, or base32")
+	var tag = flag.Bool("tag", false, "print the hash in the BSD style of 'shasum --tag', as 'SHA256-DIR (dir) = hash', like -format tag")
+	var binary = flag.Bool("binary", false, "write the raw bytes of the hash, without any encoding or newline, like -format binary")
+	var columns = flag.Bool("columns", false, "list every file with its hash, size, and path aligned into columns")
+	var width = flag.Int("width", terminalWidth(), "the line width for -columns, defaulting to $COLUMNS")
+	var wrap = flag.Bool("wrap", false, "wrap long paths in -columns output instead of truncating them")
+	var combine = flag.Bool("combine", false, "with several directories, print a single hash covering them all instead of a hash for each")
+	var stats = flag.Bool("stats", false, "after each hash, summarize on standard error the files and directories hashed, the bytes read, the entries skipped, the time taken, and the throughput")
+	var expect = flag.String("expect", "", "instead of printing the hash, compare it with this one, in hex or the -encoding, and exit with status 1 unless they match")
+	var checkpoint = flag.String("checkpoint", "", "record the hash of each directory in this file as it is finished, so that an interrupted hash resumes where it left off when run again; the file is removed once the hash succeeds")
 	flag.Parse()
 
-	hash, err := dirhash.HashDir(*hashroot)
+	// The directories may also be given as arguments, as in 'dirhash -expect HASH DIR'
+	var roots = flag.Args()
+	if len(roots) == 0 {
+		roots = []string{*hashroot}
+	}
+	if len(roots) > 1 && *expect != "" && !*combine {
+		fatalf(exitUsage, "-expect needs a single directory, or -combine")
+	}
+
+	if *stats && *oci != "" {
+		fatalf(exitUsage, "-stats can't be used with -oci")
+	}
+
+	opts, err := optFlags.options()
 	if err != nil {
-        fmt.Fprintf(os.Stderr, "error: %s\n", err)
-        os.Exit(1)
+		fatalf(exitUsage, "%s", err)
+	}
+	if *checkpoint != "" {
+		if len(roots) > 1 || *oci != "" {
+			fatalf(exitUsage, "-checkpoint needs a single directory")
+		}
+		if opts.Checkpoint, err = dirhash.OpenCheckpoint(*checkpoint); err != nil {
+			fatalf(exitError, "%s", err)
+		}
+	}
+
+	if *tag {
+		*format = "tag"
+	}
+	if *binary {
+		*format = "binary"
+	}
+	digestEncoding, err := dirhash.ParseEncoding(*encoding)
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	encoder, ok := dirhash.LookupEncoder(*format)
+	if !ok {
+		fatalf(exitUsage, "unknown format %q", *format)
+	}
+	if *columns {
+		encoder = columnEncoder{width: *width, wrap: *wrap}
+	}
+
+	report := func(result dirhash.Result) {
+		if *stats {
+			printStats(result)
+		}
+		printResult(result, encoder, *expect, *optFlags.quiet)
+	}
+
+	var template = dirhash.Result{Algorithm: opts.Algorithm, Encoding: digestEncoding}
+	fe, ok := encoder.(dirhash.FileEncoder)
+	listsFiles := ok && fe.EncodesFiles() // Collect the individual files only if they're listed
+	switch {
+	case *oci != "":
+		template.Path = *oci
+		report(hashRoot(template, opts, listsFiles, func(opts dirhash.Options) ([]byte, error) {
+			return dirhash.HashOCIWithOptions(*oci, opts)
+		}))
+	case *combine:
+		template.Path, template.Stats = strings.Join(roots, " "), new(dirhash.Stats)
+		report(hashRoot(template, opts, listsFiles, func(opts dirhash.Options) ([]byte, error) {
+			return dirhash.HashDirsWithOptions(roots, opts)
+		}))
+	default:
+		for _, root := range roots {
+			template.Path, template.Stats = root, new(dirhash.Stats)
+			report(hashRoot(template, opts, listsFiles, func(opts dirhash.Options) ([]byte, error) {
+				return dirhash.HashDirWithOptions(root, opts)
+			}))
+		}
+	}
+	if opts.Checkpoint != nil {
+		// Once the hash is done there's nothing left to resume, unless something had to be
+		// skipped, which may yet be fixed
+		if incomplete {
+			err = opts.Checkpoint.Close()
+		} else {
+			err = opts.Checkpoint.Remove()
+		}
+		if err != nil {
+			fatalf(exitError, "%s", err)
+		}
 	}
+	if incomplete {
+		os.Exit(exitError)
+	}
+}
+
+// incomplete is set once a hash has been printed which had to skip some entries under
+// -on-error collect, which makes the command fail once every hash has been printed.
+var incomplete bool
+
+// hashRoot fills in result with the hash given by hash, which is called with opts adjusted to
+// collect the statistics and the individual files, if listsFiles says they are wanted.
+func hashRoot(result dirhash.Result, opts dirhash.Options, listsFiles bool, hash func(dirhash.Options) ([]byte, error)) dirhash.Result {
+	var mu sync.Mutex
+	opts.Stats = result.Stats
+	if listsFiles {
+		opts.OnFile = func(e dirhash.Entry) {
+			mu.Lock()
+			result.Files = append(result.Files, e)
+			mu.Unlock()
+		}
+	}
+	sum, err := hash(opts)
+	if _, ok := err.(*dirhash.SkippedError); ok {
+		incomplete, err = true, nil // Each was warned about as it was skipped
+	}
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+	result.Sum = sum
+	return result
+}
+
+// printResult writes out result with encoder, or if a hash is expected, says whether it matches
+// and exits if it doesn't. If quiet is set, whether it matches is left to the exit status.
+func printResult(result dirhash.Result, encoder dirhash.Encoder, expect string, quiet bool) {
+	if expect != "" {
+		if !matchesExpected(expect, result) {
+			if !quiet {
+				fmt.Printf("MISMATCH: %s hashes to %s, not %s\n", result.Path, result.Encoding.EncodeToString(result.Sum), expect)
+			}
+			os.Exit(exitMismatch)
+		}
+		if !quiet {
+			fmt.Printf("OK: %s matches\n", result.Path)
+		}
+		return
+	}
+
+	output, err := encoder.Encode(result)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	os.Stdout.Write(output)
+}
+
+// printStats summarizes the statistics in result on standard error, for -stats.
+func printStats(result dirhash.Result) {
+	s := result.Stats
+	fmt.Fprintf(os.Stderr, "%s: %d files, %d directories, %s in %s (%s/s), %d skipped\n",
+		result.Path, s.Files, s.Dirs, formatBytes(float64(s.Bytes)), s.Elapsed.Round(time.Millisecond), formatBytes(s.Throughput()), s.Skipped)
+}
+
+// matchesExpected reports whether expected, given on the command line, is the hash in r. It may
+// be in hexadecimal of either case or in the encoding of r, with or without the prefix which
+// marks a non-cryptographic hash.
+func matchesExpected(expected string, r dirhash.Result) bool {
+	expected = strings.TrimPrefix(strings.TrimSpace(expected), r.Algorithm.String()+":")
+	if sum, err := hex.DecodeString(expected); err == nil {
+		return bytes.Equal(sum, r.Sum)
+	}
+	return expected == r.Encoding.EncodeToString(r.Sum)
+}
+
+// The exit statuses of every command, so that scripts can tell a directory which has changed
+// from one which couldn't be hashed at all.
+const (
+	exitOK       = 0 // Everything succeeded, and anything checked matched
+	exitMismatch = 1 // A check found differences, such as a modified file or the wrong hash
+	exitUsage    = 2 // The command line was invalid
+	exitError    = 3 // Something failed along the way, such as a file which couldn't be read
+)
 
-    fmt.Printf("%X\n", hash)
+// fatalf prints an error message and exits with the given status: exitUsage for a mistake in how
+// the tool was invoked, and exitError for anything going wrong afterwards.
+func fatalf(status int, format string, args ...interface{}) {
+	clearProgress()
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	os.Exit(status)
 }
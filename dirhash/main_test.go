@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the command itself in place of the tests when asked to by runDirhash, so that
+// the tests can run it as a program without building it separately.
+func TestMain(m *testing.M) {
+	if os.Getenv("DIRHASH_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runDirhash runs the command with the given arguments, and returns what it writes to standard
+// output, failing the test if it exits unsuccessfully.
+func runDirhash(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "DIRHASH_TEST_MAIN=1")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("dirhash %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out)
+}
+
+// TestColumnsSymlink checks that -columns gives a followed symbolic link the size of the file it
+// points to, not the length of the path it holds.
+func TestColumnsSymlink(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "target.txt"), []byte("123456"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.txt", filepath.Join(root, "link")); err != nil {
+		t.Skip("can't make symbolic links: ", err)
+	}
+
+	sizes := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(runDirhash(t, "-columns", "-width", "200", root)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("malformed line %q", line)
+		}
+		sizes[filepath.Base(fields[2])] = fields[1]
+	}
+	for _, name := range []string{"link", "target.txt"} {
+		if sizes[name] != "6" {
+			t.Errorf("%s has size %q, want 6", name, sizes[name])
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// manifestCommand prints the hash of every file in a directory, in the format of 'sha256sum',
+// rather than the single hash of the whole directory.
+func manifestCommand(args []string) {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash manifest [flags] [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var output = fs.String("o", "", "write the manifest to this file instead of standard output")
+	var selfHashed = fs.Bool("self-hashed", false, "end the manifest with a footer holding a hash of the manifest itself and of the directory")
+	var zero = fs.Bool("0", false, "end each line with a NUL rather than a newline, and don't escape names")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	opts.SelfHashedManifest = *selfHashed
+	opts.ZeroTerminated = *zero
+	opts.StableOutput = true // List files in a deterministic order, however many jobs there are
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fatalf(exitError, "%s", err)
+		}
+	}
+	buffered := bufio.NewWriter(out)
+	if err := dirhash.WriteManifestWithOptions(dir, buffered, opts); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+	if err := out.Close(); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// scanMetrics keeps track of the repeated hashes of a directory made by a long-running command,
+// for monitoring to collect from it in the Prometheus text format.
+type scanMetrics struct {
+	mu          sync.Mutex
+	path        string
+	scans       int64         // The hashes attempted
+	errors      int64         // The hashes which failed
+	files       int64         // The files covered by all of them
+	bytes       int64         // The bytes covered by all of them
+	skipped     int64         // The entries skipped by all of them
+	duration    time.Duration // How long the latest hash took
+	lastHash    []byte        // The latest successful hash
+	lastSuccess time.Time     // When the latest successful hash finished
+	lastChange  time.Time     // When the hash last came out different from the one before
+}
+
+// record adds a hash of the directory to the metrics, which came out as hash or failed with
+// err, along with its statistics.
+func (m *scanMetrics) record(hash []byte, err error, stats *dirhash.Stats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.scans++
+	m.files += stats.Files
+	m.bytes += stats.Bytes
+	m.skipped += stats.Skipped
+	m.duration = stats.Elapsed
+	if err != nil {
+		m.errors++
+		return
+	}
+	if m.lastHash == nil || !bytes.Equal(hash, m.lastHash) {
+		m.lastChange = now
+	}
+	m.lastHash, m.lastSuccess = hash, now
+}
+
+// ServeHTTP writes out the metrics in the Prometheus text exposition format.
+func (m *scanMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out strings.Builder
+	labels := fmt.Sprintf(`{path="%s"}`, metricLabelEscaper.Replace(m.path))
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n%s%s %v\n", name, help, name, kind, name, labels, value)
+	}
+	metric("dirhash_scans_total", "counter", "Hashes of the directory attempted.", m.scans)
+	metric("dirhash_scan_errors_total", "counter", "Hashes of the directory which failed.", m.errors)
+	metric("dirhash_files_hashed_total", "counter", "Files covered by every hash of the directory.", m.files)
+	metric("dirhash_bytes_hashed_total", "counter", "Bytes read by every hash of the directory.", m.bytes)
+	metric("dirhash_skipped_entries_total", "counter", "Entries skipped under -on-error by every hash of the directory.", m.skipped)
+	metric("dirhash_scan_duration_seconds", "gauge", "How long the latest hash of the directory took.", m.duration.Seconds())
+	metric("dirhash_last_success_timestamp_seconds", "gauge", "When the latest successful hash of the directory finished, as a Unix time.", unixSeconds(m.lastSuccess))
+	metric("dirhash_last_change_timestamp_seconds", "gauge", "When the hash of the directory last changed, as a Unix time.", unixSeconds(m.lastChange))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.String()))
+}
+
+// metricLabelEscaper escapes a label value for the Prometheus text format.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// unixSeconds returns t as fractional seconds since the Unix epoch, or zero if it is unset.
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// packCommand writes a directory to a reproducible tar archive, and prints its hash.
+func packCommand(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash pack [flags] -o FILE [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var output = fs.String("o", "", "the tar archive to write, or - for standard output")
+	fs.Parse(args)
+	if fs.NArg() > 1 || *output == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+
+	// With the archive on standard output, the hash has to go somewhere else
+	out, report := os.Stdout, os.Stdout
+	if *output == "-" {
+		report = os.Stderr
+	} else if out, err = os.Create(*output); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	buffered := bufio.NewWriter(out)
+	hash, err := dirhash.PackWithOptions(dir, buffered, opts)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	if err := out.Close(); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	fmt.Fprintf(report, "%X\n", hash)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// progressInterval is how often the progress line is redrawn at most.
+const progressInterval = 100 * time.Millisecond
+
+// progressBar draws a single, continually updated line of progress on standard error.
+type progressBar struct {
+	mu     sync.Mutex
+	start  time.Time
+	drawn  time.Time
+	files  int64
+	done   int64
+	total  int64
+	eta    time.Duration
+	shown  bool // Whether there is a line on the terminal which needs clearing
+	closed bool
+}
+
+// activeBar is the progress bar being drawn, if any, so that error messages can clear it first.
+var activeBar *progressBar
+
+// isTerminal reports whether f looks like an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// attachProgress makes opts draw a progress bar on standard error, if it is a terminal.
+func attachProgress(opts *dirhash.Options) {
+	if !isTerminal(os.Stderr) {
+		return
+	}
+	bar := &progressBar{start: time.Now()}
+	activeBar = bar
+	opts.Progress = func(e dirhash.Event) {
+		bar.mu.Lock()
+		defer bar.mu.Unlock()
+		bar.files = e.FilesHashed
+	}
+	opts.ProgressWithETA = func(done, total int64, eta time.Duration) {
+		bar.mu.Lock()
+		defer bar.mu.Unlock()
+		bar.done, bar.total, bar.eta = done, total, eta
+		if done == total && eta == 0 {
+			bar.clearLocked()
+			bar.closed = true
+			return
+		}
+		if time.Since(bar.drawn) >= progressInterval {
+			bar.drawLocked()
+		}
+	}
+}
+
+func (b *progressBar) drawLocked() {
+	if b.closed {
+		return
+	}
+	b.drawn = time.Now()
+	var percent int64
+	if b.total > 0 {
+		percent = b.done * 100 / b.total
+	}
+	rate := float64(b.done) / time.Since(b.start).Seconds()
+	fmt.Fprintf(os.Stderr, "\r\033[K%d files, %s of %s (%d%%), %s/s, ETA %s",
+		b.files, formatBytes(float64(b.done)), formatBytes(float64(b.total)), percent, formatBytes(rate), b.eta.Round(time.Second))
+	b.shown = true
+}
+
+func (b *progressBar) clearLocked() {
+	if b.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		b.shown = false
+	}
+}
+
+// clearProgress removes any progress line from the terminal for good.
+func clearProgress() {
+	if activeBar == nil {
+		return
+	}
+	activeBar.mu.Lock()
+	defer activeBar.mu.Unlock()
+	activeBar.clearLocked()
+	activeBar.closed = true
+}
+
+// formatBytes prints a number of bytes with a binary unit suffix.
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
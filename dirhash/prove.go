@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// proveCommand writes a proof that a file is included in the hash of a directory, or checks a
+// file downloaded on its own against such a proof and a root hash, exiting with status 1 if it
+// doesn't match.
+func proveCommand(args []string) {
+	fs := flag.NewFlagSet("prove", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash prove [flags] DIR FILE\n       dirhash prove -check PROOF -root HASH [FILE]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var check = fs.String("check", "", "rather than writing a proof, check this proof against -root, and the FILE given against the proof")
+	var root = fs.String("root", "", "with -check, the hash of the whole directory in hex, as published")
+	fs.Parse(args)
+
+	if *check != "" {
+		if *root == "" || fs.NArg() > 1 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		checkProof(*check, *root, fs.Arg(0), *optFlags.quiet)
+		return
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	proof, err := dirhash.Prove(fs.Arg(0), fs.Arg(1), opts)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+	data, err := json.Marshal(proof)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	fmt.Printf("%s\n", data)
+}
+
+// checkProof checks the proof in the file proofPath against the root hash rootHex, and the file
+// at path against the proof if there is one, and exits with status 1 unless both match.
+func checkProof(proofPath, rootHex, path string, quiet bool) {
+	root, err := hex.DecodeString(rootHex)
+	if err != nil {
+		fatalf(exitUsage, "-root isn't a hexadecimal hash")
+	}
+	data, err := os.ReadFile(proofPath)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	var proof dirhash.Proof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		fatalf(exitError, "%s: %s", proofPath, err)
+	}
+
+	if path != "" {
+		err = proof.VerifyFile(path, root)
+	} else {
+		err = proof.Verify(root)
+	}
+	if errors.Is(err, dirhash.ErrProofMismatch) {
+		if !quiet {
+			fmt.Printf("MISMATCH: %s\n", proof.Path)
+		}
+		os.Exit(exitMismatch)
+	}
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	if !quiet {
+		fmt.Printf("OK: %s\n", proof.Path)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// serveCommand answers HTTP requests for the hash or manifest of a single directory, so that
+// other services can check on it without running the tool themselves:
+//
+//	GET /hash      the hash of the directory, as the JSON output format prints it
+//	GET /manifest  the same, together with the hash of every file
+//	GET /metrics   metrics about every hash made so far, in the Prometheus text format
+//
+// Each request hashes the directory afresh, unless -cache lets unchanged files be skipped, and
+// a request which is abandoned stops its hash.
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash serve [flags] [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var addr = fs.String("addr", "localhost:8080", "the address to listen on")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+
+	s := &server{dir: dir, opts: opts, metrics: &scanMetrics{path: dir}}
+	http.HandleFunc("/hash", func(w http.ResponseWriter, r *http.Request) { s.handle(w, r, false) })
+	http.HandleFunc("/manifest", func(w http.ResponseWriter, r *http.Request) { s.handle(w, r, true) })
+	http.Handle("/metrics", s.metrics)
+	log.Printf("serving the hash of %s on %s", dir, *addr)
+	fatalf(exitError, "%s", http.ListenAndServe(*addr, nil))
+}
+
+// server hashes a directory on behalf of HTTP requests.
+type server struct {
+	dir     string
+	opts    dirhash.Options
+	metrics *scanMetrics
+}
+
+// handle hashes the directory for r, listing every file too if files is set.
+func (s *server) handle(w http.ResponseWriter, r *http.Request, files bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := s.opts
+	opts.Stats = new(dirhash.Stats)
+	result := dirhash.Result{Path: s.dir, Algorithm: opts.Algorithm}
+	if files {
+		var mu sync.Mutex
+		opts.StableOutput = true
+		opts.RelativeErrors = true
+		opts.OnFile = func(e dirhash.Entry) {
+			mu.Lock()
+			result.Files = append(result.Files, e)
+			mu.Unlock()
+		}
+	}
+
+	hash, err := dirhash.HashDirWithOptionsContext(r.Context(), s.dir, opts)
+	if r.Context().Err() == nil {
+		s.metrics.record(hash, err, opts.Stats)
+	}
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("error: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	result.Sum = hash
+	if opts.Cache != nil {
+		if err := opts.Cache.Save(); err != nil {
+			log.Printf("error: saving cache: %s", err)
+		}
+	}
+
+	encoder, _ := dirhash.LookupEncoder("json")
+	output, err := encoder.Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+}
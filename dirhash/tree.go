@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// treeCommand prints the hierarchy of a directory with the hash of every file and directory in
+// it, to help track down where two copies of a tree differ.
+func treeCommand(args []string) {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash tree [flags] [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var dot = fs.Bool("dot", false, "print the tree as a Graphviz DOT graph, labelled with shortened hashes")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+	if opts.ContentOnly {
+		fatalf(exitUsage, "a tree cannot be drawn with -content-only")
+	}
+	write := dirhash.WriteTreeWithOptions
+	if *dot {
+		write = dirhash.WriteTreeDOT
+	}
+	if err := write(dir, os.Stdout, opts); err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// verifyCommand checks a directory against a manifest, listing every file which was modified,
+// added, or removed, and exits with status 1 if there were any. A self-hashed manifest is
+// checked against its footer first, and refused if it has been cut short or damaged.
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash verify -manifest FILE [flags] [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var manifest = fs.String("manifest", "", "the manifest to verify the directory against")
+	var zero = fs.Bool("0", false, "end each path listed with a NUL rather than a newline")
+	var chunks = fs.Bool("chunks", false, "follow each modified file with the chunks of it which changed, if the manifest lists them")
+	fs.Parse(args)
+	if *manifest == "" || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+
+	data, err := os.ReadFile(*manifest)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	if selfHashed, err := dirhash.IsSelfHashedManifest(bytes.NewReader(data)); err != nil {
+		fatalf(exitError, "%s: %s", *manifest, err)
+	} else if selfHashed {
+		if _, err := dirhash.VerifyManifestIntegrity(bytes.NewReader(data)); err != nil {
+			fatalf(exitError, "%s: %s", *manifest, err)
+		}
+	}
+	diff, err := dirhash.VerifyManifestWithOptions(dir, bytes.NewReader(data), opts)
+	if err != nil {
+		fatalf(exitError, "%s", err)
+	}
+	saveCache(opts)
+
+	if !*optFlags.quiet {
+		printDiff(diff, *zero, *chunks)
+	}
+	if !diff.OK() {
+		os.Exit(exitMismatch)
+	}
+}
+
+// printDiff lists the paths in diff one per line, each marked with a letter saying how it
+// differs: 'M' for modified, 'A' for added, and 'D' for deleted. If zero is set, each ends with
+// a NUL instead of a newline. If chunks is set, each modified file whose chunks are known is
+// followed by a line listing those which changed, as in "  chunks 0,7".
+func printDiff(diff *dirhash.ManifestDiff, zero, chunks bool) {
+	end := "\n"
+	if zero {
+		end = "\x00"
+	}
+	for _, p := range diff.Modified {
+		fmt.Printf("M %s%s", p, end)
+		if bad, ok := diff.BadChunks[p]; chunks && ok {
+			fmt.Printf("  chunks %s%s", joinInts(bad), end)
+		}
+	}
+	for _, p := range diff.Added {
+		fmt.Printf("A %s%s", p, end)
+	}
+	for _, p := range diff.Removed {
+		fmt.Printf("D %s%s", p, end)
+	}
+}
+
+// joinInts lists the numbers in x separated by commas.
+func joinInts(x []int) string {
+	s := make([]string, len(x))
+	for i, n := range x {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/willdonnelly/dirhash"
+)
+
+// watchCommand prints the hash of a directory, and then prints it again every time it changes
+// until interrupted. With -metrics-addr, it also serves metrics about every hash for Prometheus
+// to collect, on /metrics at that address.
+func watchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: dirhash watch [flags] [DIR]\n"))
+		fs.PrintDefaults()
+	}
+	var optFlags = addOptionFlags(fs)
+	var interval = fs.Duration("interval", 2*time.Second, "how often to check the directory for changes")
+	var metricsAddr = fs.String("metrics-addr", "", "serve Prometheus metrics about the hashes on /metrics at this address, such as localhost:9100")
+	fs.Parse(args)
+	if fs.NArg() > 1 || *interval <= 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	opts, err := optFlags.options()
+	if err != nil {
+		fatalf(exitUsage, "%s", err)
+	}
+
+	metrics := &scanMetrics{path: dir}
+	opts.Stats = new(dirhash.Stats)
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go func() { fatalf(exitError, "%s", http.ListenAndServe(*metricsAddr, mux)) }()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	dirhash.WatchPolls(ctx, dir, opts, *interval, func(hash []byte, changed bool, err error) {
+		metrics.record(hash, err, opts.Stats)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return
+		}
+		if changed {
+			fmt.Printf("%X\n", hash)
+		}
+	})
+	saveCache(opts)
+}
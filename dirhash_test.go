@@ -0,0 +1,101 @@
+package dirhash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// makeTree creates a tree in a new temporary directory and returns its path. Each key of files
+// is a slash-separated path within the tree, which is created as an empty directory if it ends
+// in a slash, and otherwise as a file holding the value.
+func makeTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// writeFile replaces the contents of the file at the slash-separated path name within root.
+func writeFile(root, name, contents string) error {
+	return os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(contents), 0644)
+}
+
+// rename renames the entry at the slash-separated path from within root to to.
+func rename(root, from, to string) error {
+	return os.Rename(filepath.Join(root, filepath.FromSlash(from)), filepath.Join(root, filepath.FromSlash(to)))
+}
+
+// TestHashDirDeterministic hashes a directory with many entries over and over, both one entry
+// at a time and with entries hashed concurrently, so that anything depending on the order of
+// map iteration or on which goroutine finishes first shows up as a hash which changes.
+func TestHashDirDeterministic(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("file%03d", i)] = fmt.Sprint(i)
+		files[fmt.Sprintf("dir%02d/file", i%40)] = fmt.Sprint(i % 40)
+	}
+	root := makeTree(t, files)
+
+	want, err := HashDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 100; run++ {
+		opts := Options{}
+		if run%2 == 1 {
+			opts.DirConcurrency, opts.FileConcurrency = 8, 8
+		}
+		got, err := HashDirWithOptions(root, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("run %d hashed to %X, want %X", run, got, want)
+		}
+	}
+}
+
+// TestHashDirDeep hashes a tree nested far deeper than any real one, with too little stack to
+// have recursed down through it.
+func TestHashDirDeep(t *testing.T) {
+	const depth = 1500
+	root := t.TempDir()
+	path := root
+	for i := 0; i < depth; i++ {
+		path = filepath.Join(path, "d")
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Skip("can't make a tree this deep: ", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "f"), []byte("bottom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer debug.SetMaxStack(debug.SetMaxStack(256 << 10))
+	if _, err := HashDir(root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HashDirWithOptions(root, Options{NestingLimit: 100}); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("hashing under NestingLimit returned %v, want ErrTooDeep", err)
+	}
+}
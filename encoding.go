@@ -0,0 +1,179 @@
+package dirhash
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Result is the outcome of hashing a directory, in the form handed to an Encoder.
+type Result struct {
+	Path      string    // The directory which was hashed
+	Algorithm Algorithm // The algorithm it was hashed with
+	Sum       []byte    // The hash of the directory
+	Files     []Entry   // The individual files which were hashed, if they were collected
+	Stats     *Stats    // Statistics about the hash, if they were kept
+	Encoding  Encoding  // How the "hex" and "json" formats write hashes, capitalized hex by default
+}
+
+// An Encoder renders a Result into some output format.
+type Encoder interface {
+	Encode(r Result) ([]byte, error)
+}
+
+// EncoderFunc adapts an ordinary function into an Encoder.
+type EncoderFunc func(r Result) ([]byte, error)
+
+// Encode calls f(r).
+func (f EncoderFunc) Encode(r Result) ([]byte, error) {
+	return f(r)
+}
+
+// A FileEncoder is an Encoder which lists individual files, and so needs Result.Files to be
+// collected for it.
+type FileEncoder interface {
+	Encoder
+	EncodesFiles() bool
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		"hex":       EncoderFunc(encodeHex),
+		"base64":    EncoderFunc(encodeBase64),
+		"json":      EncoderFunc(encodeJSON),
+		"bsd":       bsdEncoder{},
+		"tag":       EncoderFunc(encodeTag),
+		"binary":    EncoderFunc(encodeBinary),
+		"multihash": EncoderFunc(encodeMultihash),
+		"cid":       EncoderFunc(encodeCID),
+		"hashdeep":  hashdeepEncoder{},
+	}
+)
+
+// RegisterEncoder makes an encoder available under the given name, replacing any encoder which
+// was previously registered with that name.
+func RegisterEncoder(name string, e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[name] = e
+}
+
+// LookupEncoder returns the encoder registered with the given name, if there is one. The
+// built-in encoders are "hex", "base64", "json", "bsd", "tag", "binary", "multihash", "cid", and "hashdeep".
+func LookupEncoder(name string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	e, ok := encoders[name]
+	return e, ok
+}
+
+// EncoderNames lists the names of all registered encoders in alphabetical order.
+func EncoderNames() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	var names []string
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sumPrefix returns the prefix marking a bare hash as having been computed with a
+// non-cryptographic algorithm, such as "xxh64:", so that it can never be mistaken for a real
+// cryptographic directory hash. Cryptographic hashes have no prefix.
+func sumPrefix(a Algorithm) string {
+	if a.Cryptographic() {
+		return ""
+	}
+	return a.String() + ":"
+}
+
+// encodeHex prints the directory hash in capitalized hexadecimal, or whichever other encoding
+// the result asks for.
+func encodeHex(r Result) ([]byte, error) {
+	return []byte(sumPrefix(r.Algorithm) + r.Encoding.EncodeToString(r.Sum) + "\n"), nil
+}
+
+// encodeBinary writes the raw bytes of the directory hash, with nothing before or after them.
+func encodeBinary(r Result) ([]byte, error) {
+	return r.Sum, nil
+}
+
+// encodeBase64 prints the directory hash in standard padded base64.
+func encodeBase64(r Result) ([]byte, error) {
+	return []byte(sumPrefix(r.Algorithm) + base64.StdEncoding.EncodeToString(r.Sum) + "\n"), nil
+}
+
+// jsonVersion is the version of the layout of the "json" format, which only changes when fields
+// are removed or change meaning.
+const jsonVersion = 1
+
+// encodeJSON prints the whole result as a single JSON object, with hashes in the result's
+// encoding, capitalized hexadecimal by default, and the elapsed time in seconds.
+func encodeJSON(r Result) ([]byte, error) {
+	type jsonFile struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+		Hash string `json:"hash"`
+	}
+	type jsonStats struct {
+		Files   int64   `json:"files"`
+		Dirs    int64   `json:"dirs"`
+		Bytes   int64   `json:"bytes"`
+		Skipped int64   `json:"skipped"`
+		Elapsed float64 `json:"elapsed"`
+	}
+	type jsonResult struct {
+		Version   int        `json:"version"`
+		Path      string     `json:"path"`
+		Algorithm string     `json:"algorithm"`
+		Hash      string     `json:"hash"`
+		Stats     *jsonStats `json:"stats,omitempty"`
+		Files     []jsonFile `json:"files,omitempty"`
+	}
+
+	out := jsonResult{Version: jsonVersion, Path: r.Path, Algorithm: r.Algorithm.String(), Hash: r.Encoding.EncodeToString(r.Sum)}
+	if s := r.Stats; s != nil {
+		out.Stats = &jsonStats{s.Files, s.Dirs, s.Bytes, s.Skipped, s.Elapsed.Seconds()}
+	}
+	for _, f := range r.Files {
+		out.Files = append(out.Files, jsonFile{f.Path, f.Size, r.Encoding.EncodeToString(f.Sum)})
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// encodeTag prints the directory hash in the style of 'shasum --tag', as in
+//
+//	SHA256-DIR (path/to/dir) = <hash in lowercase hexadecimal>
+//
+// with the algorithm name in capitals, marked as the hash of a directory rather than a file.
+func encodeTag(r Result) ([]byte, error) {
+	return []byte(fmt.Sprintf("%s-DIR (%s) = %x\n", strings.ToUpper(r.Algorithm.String()), r.Path, r.Sum)), nil
+}
+
+// bsdEncoder lists every file in the tagged format of 'shasum --tag', in order of path, with the
+// algorithm name in capitals as the tag. It doesn't include the directory hash at all.
+type bsdEncoder struct{}
+
+func (bsdEncoder) EncodesFiles() bool { return true }
+
+func (bsdEncoder) Encode(r Result) ([]byte, error) {
+	files := append([]Entry(nil), r.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	var out strings.Builder
+	tag := strings.ToUpper(r.Algorithm.String())
+	for _, f := range files {
+		fmt.Fprintf(&out, "%s (%s) = %x\n", tag, f.Path, f.Sum)
+	}
+	return []byte(out.String()), nil
+}
@@ -0,0 +1,131 @@
+package dirhash
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// testResult is a result with a short made-up hash, listing two files out of order.
+var testResult = Result{
+	Path:      "some/dir",
+	Algorithm: AlgorithmSHA256,
+	Sum:       []byte{0xde, 0xad, 0xbe, 0xef},
+	Files: []Entry{
+		{Path: "some/dir/b", Size: 2, Sum: []byte{0x0b}},
+		{Path: "some/dir/a", Size: 1, Sum: []byte{0x0a}},
+	},
+}
+
+func TestEncoders(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"hex", "DEADBEEF\n"},
+		{"base64", "3q2+7w==\n"},
+		{"binary", "\xde\xad\xbe\xef"},
+		{"tag", "SHA256-DIR (some/dir) = deadbeef\n"},
+		{"bsd", "SHA256 (some/dir/a) = 0a\nSHA256 (some/dir/b) = 0b\n"},
+	} {
+		e, ok := LookupEncoder(test.name)
+		if !ok {
+			t.Errorf("no %q encoder", test.name)
+			continue
+		}
+		got, err := e.Encode(testResult)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: encoded as %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEncoderEncodings(t *testing.T) {
+	hex, _ := LookupEncoder("hex")
+	r := testResult
+	r.Encoding = EncodingLowerHex
+	if got, _ := hex.Encode(r); string(got) != "deadbeef\n" {
+		t.Errorf("hex under EncodingLowerHex encoded as %q, want %q", got, "deadbeef\n")
+	}
+
+	// A non-cryptographic hash is marked as one
+	r = testResult
+	r.Algorithm = AlgorithmXXH64
+	if got, _ := hex.Encode(r); string(got) != "xxh64:DEADBEEF\n" {
+		t.Errorf("hex of an xxh64 hash encoded as %q, want %q", got, "xxh64:DEADBEEF\n")
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	e, _ := LookupEncoder("json")
+	data, err := e.Encode(testResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Version   int    `json:"version"`
+		Path      string `json:"path"`
+		Algorithm string `json:"algorithm"`
+		Hash      string `json:"hash"`
+		Files     []struct {
+			Path string `json:"path"`
+			Size int64  `json:"size"`
+			Hash string `json:"hash"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("%v in %s", err, data)
+	}
+	if got.Version != jsonVersion || got.Path != "some/dir" || got.Algorithm != "sha256" || got.Hash != "DEADBEEF" {
+		t.Errorf("encoded as %s", data)
+	}
+	if len(got.Files) != 2 || got.Files[0].Path != "some/dir/b" || got.Files[0].Size != 2 || got.Files[0].Hash != "0B" {
+		t.Errorf("files encoded as %s", data)
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	custom := EncoderFunc(func(r Result) ([]byte, error) { return []byte(r.Path), nil })
+	RegisterEncoder("test-custom", custom)
+	defer func() {
+		encodersMu.Lock()
+		delete(encoders, "test-custom")
+		encodersMu.Unlock()
+	}()
+
+	e, ok := LookupEncoder("test-custom")
+	if !ok {
+		t.Fatal("registered encoder not found")
+	}
+	if got, _ := e.Encode(testResult); string(got) != "some/dir" {
+		t.Errorf("registered encoder encoded as %q", got)
+	}
+	names := EncoderNames()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("names are out of order: %v", names)
+	}
+	var found bool
+	for _, name := range names {
+		found = found || name == "test-custom"
+	}
+	if !found {
+		t.Errorf("registered encoder missing from %v", names)
+	}
+	if _, ok := LookupEncoder("no-such-encoder"); ok {
+		t.Error("found an encoder which was never registered")
+	}
+}
+
+func TestFileEncoders(t *testing.T) {
+	for name, wantFiles := range map[string]bool{"hex": false, "json": false, "bsd": true, "hashdeep": true} {
+		e, _ := LookupEncoder(name)
+		fe, ok := e.(FileEncoder)
+		if got := ok && fe.EncodesFiles(); got != wantFiles {
+			t.Errorf("%s lists files: %t, want %t", name, got, wantFiles)
+		}
+	}
+}
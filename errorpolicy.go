@@ -0,0 +1,132 @@
+package dirhash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// ErrorPolicy says what to do about entries in the tree which can't be read.
+type ErrorPolicy int
+
+const (
+	// ErrorFailFast, the default, abandons the hash at the first entry which can't be read, and
+	// returns its error.
+	ErrorFailFast ErrorPolicy = iota
+
+	// ErrorSkip carries on past any file or subdirectory which can't be read, reporting each to
+	// OnSkip. A skipped entry is still listed in its directory's pseudo-file, with "!" in place
+	// of its hash and without any attributes, so the hash records exactly what was skipped and
+	// differs from the hash of the tree without it.
+	ErrorSkip
+
+	// ErrorCollect skips entries just as ErrorSkip does, and then returns the hash of what could
+	// be read together with a *SkippedError listing everything which couldn't.
+	ErrorCollect
+)
+
+var errorPolicyNames = map[ErrorPolicy]string{
+	ErrorFailFast: "fail",
+	ErrorSkip:     "skip",
+	ErrorCollect:  "collect",
+}
+
+// ParseErrorPolicy returns the policy with the given name, as returned by ErrorPolicy.String.
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	for p, n := range errorPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown error policy %q", name)
+}
+
+// String returns the short lowercase name of the policy, such as "skip".
+func (p ErrorPolicy) String() string {
+	if name, ok := errorPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+}
+
+// skippedPlaceholder stands in for the hash of an entry skipped under ErrorSkip or ErrorCollect.
+const skippedPlaceholder = "!"
+
+// SkippedError is returned under ErrorCollect, along with the hash, when any entries had to be
+// skipped. It holds the error for each, in order of the path of the entry skipped.
+type SkippedError struct {
+	Errors []error
+}
+
+func (e *SkippedError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("skipped 1 entry: %s", e.Errors[0])
+	}
+	return fmt.Sprintf("skipped %d entries, the first: %s", len(e.Errors), e.Errors[0])
+}
+
+func (e *SkippedError) Unwrap() []error {
+	return e.Errors
+}
+
+// skippedEntries collects the entries skipped under ErrorCollect.
+type skippedEntries struct {
+	mu      sync.Mutex
+	entries []skippedEntry
+}
+
+type skippedEntry struct {
+	path string
+	err  error
+}
+
+// skippable reports whether an entry which failed with err may be skipped. Only failures to read
+// something are; errors enforcing the options themselves, such as a symbolic link refused by
+// the SymlinkMode, fail whatever the policy. A locked file goes by OnLocked, unless that leaves
+// it to OnError.
+func (w *walker) skippable(err error) bool {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return false
+	}
+	if w.opts.OnLocked != LockedAsError && errors.Is(err, ErrFileLocked) {
+		return w.opts.OnLocked == LockedSkip
+	}
+	if w.opts.OnError == ErrorFailFast {
+		return false
+	}
+	return !errors.Is(err, ErrSymlink) && !errors.Is(err, ErrSymlinkCycle) && !errors.Is(err, ErrTooDeep) && !errors.Is(err, ErrSpecialFile)
+}
+
+// skip records that the entry at path was skipped because of err.
+func (w *walker) skip(path string, err error, events *eventLog) {
+	err = w.relativeError(err)
+	w.logSkip(path, err)
+	w.countSkipped()
+	w.skippedAny.Store(true)
+	if w.opts.OnError == ErrorCollect {
+		w.skipped.mu.Lock()
+		w.skipped.entries = append(w.skipped.entries, skippedEntry{path, err})
+		w.skipped.mu.Unlock()
+	}
+	if w.opts.OnSkip != nil {
+		display := w.display(path)
+		events.emit(func() { w.opts.OnSkip(display, err) })
+	}
+}
+
+// skippedError returns the error listing everything skipped under ErrorCollect, if anything was.
+func (w *walker) skippedError() error {
+	if len(w.skipped.entries) == 0 {
+		return nil
+	}
+	entries := w.skipped.entries
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		errs[i] = e.err
+	}
+	return &SkippedError{Errors: errs}
+}
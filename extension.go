@@ -0,0 +1,43 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// HashByExtension hashes the directory at root like HashDir, and also hashes the files of each
+// extension separately, so that a build system can key a target on only the kinds of file it
+// actually consumes. The map is keyed by extension including the leading dot, such as ".go",
+// with files that have no extension grouped under "". Each extension's hash is the SHA256 of a
+// flat listing of its files, one per line, ordered by the bytes of their paths:
+//
+//	F5F12CF4210548CB4794FA08DD099186F5C4B3424BDC6535F1E63C2EBCD882BE "cmd/main.go"
+//	EAD9E82A649437D8A03BE6756862DC2B058976B565440FDAE81FBD9960128B4E "util.go"
+//
+// Paths are relative to root, separated by '/', and escaped just like names in a pseudo-file.
+func HashByExtension(root string) (map[string][]byte, []byte, error) {
+	hash, files, err := collectFiles(root, Options{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Split the files up by extension, with each group in order
+	groups := make(map[string][]string)
+	for p := range files {
+		ext := path.Ext(p)
+		groups[ext] = append(groups[ext], p)
+	}
+
+	sums := make(map[string][]byte)
+	for ext, paths := range groups {
+		sort.Strings(paths)
+		hasher := sha256.New()
+		for _, p := range paths {
+			fmt.Fprintf(hasher, "%X \"%s\"\n", files[p], escape(p))
+		}
+		sums[ext] = hasher.Sum(nil)
+	}
+	return sums, hash, nil
+}
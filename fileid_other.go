@@ -0,0 +1,20 @@
+//go:build !unix
+
+package dirhash
+
+import "os"
+
+// fileID always fails, since there are no inode numbers to identify files by here.
+func fileID(info os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
+
+// fileOwner always fails, since files have no numeric owners here.
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// fileLinks always fails, since there are no link counts to be had here.
+func fileLinks(info os.FileInfo) (nlink uint64, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package dirhash
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the device and inode numbers of the file described by info.
+func fileID(info os.FileInfo) (dev, ino uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), true
+}
+
+// fileOwner returns the user and group IDs of the owner of the file described by info.
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
+
+// fileLinks returns the number of hard links to the file described by info.
+func fileLinks(info os.FileInfo) (nlink uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
@@ -0,0 +1,51 @@
+package dirhash
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// checkPatterns makes sure every Include and Exclude pattern is well-formed, so that a typo is
+// reported up front rather than silently matching nothing.
+func (w *walker) checkPatterns() error {
+	for _, patterns := range [][]string{w.opts.Include, w.opts.Exclude} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// filtered reports whether the entry at path is left out by the Include and Exclude patterns.
+func (w *walker) filtered(path string, dir bool) bool {
+	rel := strings.TrimPrefix(path, w.root+"/")
+	for _, p := range w.opts.Exclude {
+		if matchPattern(p, rel) {
+			return true
+		}
+	}
+	if dir || len(w.opts.Include) == 0 {
+		return false
+	}
+	for _, p := range w.opts.Include {
+		if matchPattern(p, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPattern reports whether a glob matches an entry, given its slash-separated path relative
+// to the root. A pattern without a slash is matched against the entry's name alone, so that
+// "*.o" applies at every depth, while one with a slash must match the whole relative path.
+func matchPattern(pattern, rel string) bool {
+	name := rel
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,44 @@
+package dirhash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by FindMatching when no directory has the hash being searched for.
+var ErrNotFound = errors.New("no matching directory found")
+
+// errFound stops the search once a match has turned up.
+var errFound = errors.New("found")
+
+// FindMatching searches the tree at root, including root itself, for a directory whose hash is
+// target, and returns its path. Directories are searched depth-first in order of name, with
+// each directory checked only after everything beneath it, and the search stops as soon as a
+// match is found. ErrNotFound is returned if there is none.
+func FindMatching(root string, target []byte) (string, error) {
+	return FindMatchingContext(context.Background(), root, target)
+}
+
+// FindMatchingContext is like FindMatching, but abandons the search once ctx is done.
+func FindMatchingContext(ctx context.Context, root string, target []byte) (string, error) {
+	var found string
+	w := newWalker(&Options{})
+	w.ctx = ctx
+	w.onDir = func(path string, hash []byte) error {
+		if bytes.Equal(hash, target) {
+			found = path
+			return errFound
+		}
+		return nil
+	}
+
+	_, err := w.hashDir(root, 0, nil)
+	if err == errFound {
+		return found, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", ErrNotFound
+}
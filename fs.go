@@ -0,0 +1,105 @@
+package dirhash
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// HashFS performs the directory hashing algorithm on the directory root within fsys, such as an
+// embed.FS, a zip.Reader, or an in-memory filesystem. It gives exactly the same hash as HashDir
+// would for an identical tree on disk. As with fs.FS in general, root is a slash-separated path
+// without any leading slash, and "." for the top of fsys.
+func HashFS(fsys fs.FS, root string) ([]byte, error) {
+	return HashFSWithOptions(fsys, root, Options{})
+}
+
+// HashFSWithOptions is like HashFS, tuned by opts in the same way as HashDirWithOptions. Options
+// which need more than an fs.FS provides have no effect: IncludeCapabilities records nothing.
+// SymlinkFollowAll, which needs to resolve real paths to detect cycles, isn't supported.
+func HashFSWithOptions(fsys fs.FS, root string, opts Options) ([]byte, error) {
+	if opts.Symlinks == SymlinkFollowAll {
+		return nil, errors.New("SymlinkFollowAll is not supported by HashFS")
+	}
+	w := newWalker(&opts)
+	w.fsys = fsys
+	return w.run(root)
+}
+
+// join returns the path of the entry called name within the directory dir. Paths on disk are
+// simply joined with a slash, as they always have been, but paths within an fs.FS must also
+// be kept in the clean form which fs.ValidPath requires.
+func (w *walker) join(dir, name string) string {
+	if w.fsys != nil {
+		return path.Join(dir, name)
+	}
+	return dir + "/" + name
+}
+
+// open opens the file at path for reading, waiting for room under MaxOpenFiles if need be.
+func (w *walker) open(path string) (fs.File, error) {
+	if err := w.acquireFiles(1); err != nil {
+		return nil, err
+	}
+	return w.openAcquired(path)
+}
+
+// openFile opens the file at path for reading, on disk or within fsys, without any waiting or
+// retrying.
+func (w *walker) openFile(path string) (fs.File, error) {
+	if w.fsys != nil {
+		return w.fsys.Open(path)
+	}
+	file, err := openDisk(path)
+	if err != nil {
+		return nil, diskError(err, path)
+	}
+	return file, nil
+}
+
+// diskFile returns the *os.File beneath file, as opened by open, if it was opened from the disk
+// and is read as it is, rather than through ReadRetries.
+func diskFile(file fs.File) (*os.File, bool) {
+	if b, ok := file.(*budgetedFile); ok {
+		file = b.File
+	}
+	f, ok := file.(*os.File)
+	return f, ok
+}
+
+// stat returns information about whatever is at path, following any symbolic link.
+func (w *walker) stat(path string) (os.FileInfo, error) {
+	if w.fsys != nil {
+		return fs.Stat(w.fsys, path)
+	}
+	info, err := os.Stat(diskPath(path))
+	return info, diskError(err, path)
+}
+
+// lstat returns information about whatever is at path, without following a symbolic link.
+func (w *walker) lstat(path string) (os.FileInfo, error) {
+	if w.fsys != nil {
+		return fs.Lstat(w.fsys, path)
+	}
+	info, err := os.Lstat(diskPath(path))
+	return info, diskError(err, path)
+}
+
+// readLink returns the destination of the symbolic link at path.
+func (w *walker) readLink(path string) (string, error) {
+	if w.fsys != nil {
+		return fs.ReadLink(w.fsys, path)
+	}
+	target, err := os.Readlink(diskPath(path))
+	return target, diskError(err, path)
+}
+
+// diskError puts path back into err, in place of the form of it which diskPath gave the system,
+// so that errors name paths just as they were walked.
+func diskError(err error, path string) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		pathErr.Path = path
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package dirhash
+
+// diskPath returns the form of path to hand to the system, which is path itself everywhere but
+// Windows.
+func diskPath(path string) string {
+	return path
+}
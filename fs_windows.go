@@ -0,0 +1,35 @@
+package dirhash
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// diskPath returns the form of path to hand to the system. The os package already turns paths
+// too long for the old limit of 260 characters into the extended-length form, beginning with
+// \\?\, which has no such limit, so trees holding long paths hash like any other. A root which
+// is given in that form already, though, is taken literally by the system, which then doesn't
+// understand the slashes the walker joins names with, nor the doubled separator after a root
+// such as \\?\C:\. Such paths are cleaned up into the form the system expects.
+func diskPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return `\\?\` + filepath.Clean(path[len(`\\?\`):])
+	}
+	return path
+}
+
+// extendedPath returns path in the extended-length form, beginning with \\?\, for the calls
+// which os doesn't make itself and which would otherwise be held to the old limit on length.
+func extendedPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return diskPath(path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[len(`\\`):]
+	}
+	return `\\?\` + abs
+}
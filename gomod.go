@@ -0,0 +1,104 @@
+package dirhash
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HashGoModule computes the "h1:" hash which the go command records in go.sum for the module
+// in the directory dir, as golang.org/x/mod/sumdb/dirhash computes it, so that a module can be
+// checked against go.sum without the go command. The prefix is the module path and version
+// joined by "@", such as "golang.org/x/text@v0.14.0", exactly as it appears in the module's
+// zip file.
+//
+// The H1 algorithm is entirely separate from the one HashDir uses. It is the SHA256 of the
+// 'sha256sum' listing of every file in the module, with each name beginning with the prefix,
+// sorted by name, and then encoded in base64. Directories contribute nothing of their own.
+func HashGoModule(dir, prefix string) (string, error) {
+	var files []string
+	dir = filepath.Clean(dir)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if file == dir {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		rel := file
+		if dir != "." {
+			rel = file[len(dir)+1:]
+		}
+		files = append(files, filepath.ToSlash(filepath.Join(prefix, rel)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hashH1(files, func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, strings.TrimPrefix(name, prefix)))
+	})
+}
+
+// HashGoModuleZip computes the "h1:" hash of the module zip file at path, as the go command
+// records it in go.sum. The files in the zip already carry the module path and version.
+func HashGoModuleZip(path string) (string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	var files []string
+	byName := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files = append(files, file.Name)
+		byName[file.Name] = file
+	}
+	return hashH1(files, func(name string) (io.ReadCloser, error) {
+		return byName[name].Open()
+	})
+}
+
+// HashGoMod computes the "h1:" hash of the go.mod file at path, as the go command records it in
+// go.sum on the line ending "/go.mod".
+func HashGoMod(path string) (string, error) {
+	return hashH1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+// hashH1 computes the H1 hash of the named files, read using open.
+func hashH1(files []string, open func(name string) (io.ReadCloser, error)) (string, error) {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+
+	hasher := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file, "\n") {
+			return "", errors.New("filenames with newlines cannot be hashed with H1")
+		}
+		r, err := open(file)
+		if err != nil {
+			return "", err
+		}
+		fileHasher := sha256.New()
+		_, err = io.Copy(fileHasher, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hasher, "%x  %s\n", fileHasher.Sum(nil), file)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
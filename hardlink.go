@@ -0,0 +1,142 @@
+package dirhash
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// inode identifies a file by its device and inode numbers.
+type inode struct {
+	dev, ino uint64
+}
+
+// findHardlinks walks the tree at root before it is hashed under MetadataLinks, recording the
+// first path in the tree of every file with more than one link. Directories which can't be
+// listed are passed over if they may be skipped, leaving the hash itself to deal with them.
+func (w *walker) findHardlinks(root string) error {
+	w.hardlinks = make(map[inode]string)
+	stack := []walkDir{{root, 0}}
+	for len(stack) > 0 {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if err := w.checkNesting(dir.path, dir.depth); err != nil {
+			return err
+		}
+		contents, err := w.listDir(dir.path)
+		if err != nil && w.skippable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, x := range contents {
+			path := w.join(dir.path, x.Name())
+			if x.IsDir() {
+				descend, err := w.descends(x, dir.depth+1)
+				if err != nil && !w.skippable(err) {
+					return err
+				}
+				if descend {
+					stack = append(stack, walkDir{path, dir.depth + 1})
+				}
+				continue
+			}
+			info, err := x.Info()
+			if err != nil && w.skippable(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			dev, ino, ok := fileID(info)
+			if nlink, _ := fileLinks(info); !ok || nlink < 2 {
+				continue
+			}
+			rel := w.relativePath(path)
+			if first, seen := w.hardlinks[inode{dev, ino}]; !seen || rel < first {
+				w.hardlinks[inode{dev, ino}] = rel
+			}
+		}
+	}
+	return nil
+}
+
+// relativePath returns path relative to the root.
+func (w *walker) relativePath(path string) string {
+	return strings.TrimPrefix(path, w.root+"/")
+}
+
+// linkAttributes returns the attributes recording the hard links to the file at path, as
+// described by info, under MetadataLinks. A file which turned up too late to be seen by
+// findHardlinks is taken to be the first of its links.
+func (w *walker) linkAttributes(path string, info os.FileInfo) string {
+	if w.opts.Metadata&MetadataLinks == 0 {
+		return ""
+	}
+	dev, ino, ok := fileID(info)
+	nlink, _ := fileLinks(info)
+	if !ok || nlink < 2 {
+		return ""
+	}
+	first, ok := w.hardlinks[inode{dev, ino}]
+	if !ok {
+		first = w.relativePath(path)
+	}
+	return fmt.Sprintf(" nlink=%d link=\"%s\"", nlink, escape(first))
+}
+
+// linkedHashes remembers the hash of every file with several links once it has been read, so
+// that the other links to it needn't be read again.
+type linkedHashes struct {
+	mu     sync.Mutex
+	hashes map[inode]*linkedHash
+}
+
+// linkedHash is the hash of a file with several links, which is ready once done is closed.
+type linkedHash struct {
+	done chan struct{}
+	hash []byte // Nil if reading the file failed
+}
+
+// hashLinked returns the hash of the contents of the file described by info, as computed by
+// hash, unless another link to the same file has been hashed already, in which case that hash
+// is reused. While one link is being read, any others wait for it to finish; if it fails, they
+// each try for themselves, so that the error is reported with the right path. Copies, archives,
+// and Transform need every link read afresh.
+func (w *walker) hashLinked(info os.FileInfo, hash func() ([]byte, error)) ([]byte, error) {
+	if w.copyTo != "" || w.packTo != nil || w.opts.Transform != nil || !info.Mode().IsRegular() {
+		return hash()
+	}
+	dev, ino, ok := fileID(info)
+	if nlink, _ := fileLinks(info); !ok || nlink < 2 {
+		return hash()
+	}
+
+	key := inode{dev, ino}
+	w.linked.mu.Lock()
+	if w.linked.hashes == nil {
+		w.linked.hashes = make(map[inode]*linkedHash)
+	}
+	if shared, ok := w.linked.hashes[key]; ok {
+		w.linked.mu.Unlock()
+		select {
+		case <-shared.done:
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		}
+		if shared.hash != nil {
+			return shared.hash, nil
+		}
+		return hash()
+	}
+	shared := &linkedHash{done: make(chan struct{})}
+	w.linked.hashes[key] = shared
+	w.linked.mu.Unlock()
+
+	sum, err := hash()
+	shared.hash = sum
+	close(shared.done)
+	return sum, err
+}
@@ -0,0 +1,153 @@
+package dirhash
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// hashdeepHeader is the line every hashdeep audit file begins with.
+const hashdeepHeader = "%%%% HASHDEEP-1.0"
+
+// hashdeepEncoder lists every file in the audit file format of hashdeep, in order of path, with
+// a single hash column named after the algorithm. As with the "bsd" format, only plain hashes
+// of file contents are understood by other tools, so DomainSeparateNodes shouldn't be used.
+type hashdeepEncoder struct{}
+
+func (hashdeepEncoder) EncodesFiles() bool { return true }
+
+func (hashdeepEncoder) Encode(r Result) ([]byte, error) {
+	files := append([]Entry(nil), r.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s\n%%%%%%%% size,%s,filename\n##\n", hashdeepHeader, r.Algorithm)
+	for _, f := range files {
+		if strings.ContainsAny(f.Path, "\n\r") {
+			return nil, fmt.Errorf("%q: hashdeep cannot list names containing line breaks", f.Path)
+		}
+		fmt.Fprintf(&out, "%d,%x,%s\n", f.Size, f.Sum, f.Path)
+	}
+	return []byte(out.String()), nil
+}
+
+// ReadHashdeep reads a hashdeep or md5deep audit file, such as 'hashdeep -r' writes, keeping the
+// first column of hashes whose algorithm is one this package supports. Paths are kept exactly as
+// written, which is however the directory was named when the file was made.
+func ReadHashdeep(r io.Reader) (*Manifest, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	manifest := &Manifest{Files: make(map[string][]byte)}
+	column, columns := -1, 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == 1:
+			if text != hashdeepHeader {
+				return nil, errors.New("not a hashdeep audit file")
+			}
+		case strings.HasPrefix(text, "%%%% "):
+			// The header naming the columns, which are the size, the hashes, and the name
+			names := strings.Split(strings.TrimPrefix(text, "%%%% "), ",")
+			columns, column = len(names), -1
+			for i, name := range names[1 : len(names)-1] {
+				if algorithm, err := ParseAlgorithm(name); err == nil {
+					manifest.Algorithm, column = algorithm, i+1
+					break
+				}
+			}
+			if column < 0 {
+				return nil, fmt.Errorf("line %d: none of the hashes %s are supported", line, strings.Join(names[1:len(names)-1], ", "))
+			}
+		case text == "" || strings.HasPrefix(text, "#"):
+			continue
+		default:
+			if column < 0 {
+				return nil, fmt.Errorf("line %d: file listed before the column header", line)
+			}
+			fields := strings.SplitN(text, ",", columns)
+			if len(fields) != columns {
+				return nil, fmt.Errorf("line %d: expected %d fields", line, columns)
+			}
+			sum, err := hex.DecodeString(fields[column])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed hash", line)
+			}
+			manifest.Files[fields[columns-1]] = sum
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Audit is the outcome of auditing a directory against a set of known hashes, with the meanings
+// hashdeep gives them in audit mode. Each list is sorted by path.
+type Audit struct {
+	Matched []string    // Files whose path and hash are both known
+	Moved   []MovedFile // Files whose hash is known, but under some other path
+	New     []string    // Files whose hash isn't known at all, including modified files
+	Missing []string    // Known paths whose hash wasn't found anywhere
+}
+
+// MovedFile is a file whose contents were known under a different path.
+type MovedFile struct {
+	Path      string // Where the file is now
+	KnownPath string // Where a file with the same hash was known to be
+}
+
+// OK reports whether the audit passed: every file was matched, and every known file found.
+func (a *Audit) OK() bool {
+	return len(a.Moved) == 0 && len(a.New) == 0 && len(a.Missing) == 0
+}
+
+// AuditHashdeep hashes the directory at path according to opts, using the algorithm of known,
+// and audits every file against the known hashes as 'hashdeep -a' would. Paths are compared as
+// they are displayed, beginning with path itself unless RelativeErrors is set, so path should
+// be given just as it was when the known hashes were made.
+func AuditHashdeep(path string, known *Manifest, opts Options) (*Audit, error) {
+	byHash := make(map[string][]string)
+	for p, sum := range known.Files {
+		byHash[string(sum)] = append(byHash[string(sum)], p)
+	}
+
+	opts.Algorithm, opts.NewHash, opts.DomainSeparateNodes = known.Algorithm, nil, false
+	var mu sync.Mutex
+	var audit Audit
+	found := make(map[string]bool) // The known hashes which some file had
+	opts.OnFile = func(e Entry) {
+		mu.Lock()
+		defer mu.Unlock()
+		knownPaths, ok := byHash[string(e.Sum)]
+		found[string(e.Sum)] = found[string(e.Sum)] || ok
+		switch {
+		case !ok:
+			audit.New = append(audit.New, e.Path)
+		case string(known.Files[e.Path]) == string(e.Sum):
+			audit.Matched = append(audit.Matched, e.Path)
+		default:
+			sort.Strings(knownPaths)
+			audit.Moved = append(audit.Moved, MovedFile{e.Path, knownPaths[0]})
+		}
+	}
+	if _, err := HashDirWithOptions(path, opts); err != nil {
+		return nil, err
+	}
+
+	for p, sum := range known.Files {
+		if !found[string(sum)] {
+			audit.Missing = append(audit.Missing, p)
+		}
+	}
+	sort.Strings(audit.Matched)
+	sort.Slice(audit.Moved, func(i, j int) bool { return audit.Moved[i].Path < audit.Moved[j].Path })
+	sort.Strings(audit.New)
+	sort.Strings(audit.Missing)
+	return &audit, nil
+}
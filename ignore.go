@@ -0,0 +1,204 @@
+package dirhash
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is a single pattern from an ignore file, compiled to match paths relative to the
+// directory holding the file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool // The pattern began with '!', and re-includes whatever it matches
+	dirOnly bool // The pattern ended with '/', and matches only directories
+}
+
+// ignoreRules are the rules in effect within one directory: those from its own ignore files,
+// which take precedence, followed by those inherited from the directories above it.
+type ignoreRules struct {
+	parent *ignoreRules
+	base   string // The directory holding the ignore files, relative to the root, or "" for the root
+	rules  []ignoreRule
+}
+
+// ignored reports whether the entry at rel, relative to the root, is ignored. Within a single
+// ignore file the last matching pattern decides, and files nearer to the entry override those
+// further up the tree, just as in git.
+func (r *ignoreRules) ignored(rel string, dir bool) bool {
+	for ; r != nil; r = r.parent {
+		sub := rel
+		if r.base != "" {
+			sub = strings.TrimPrefix(rel, r.base+"/")
+		}
+		for i := len(r.rules) - 1; i >= 0; i-- {
+			rule := r.rules[i]
+			if rule.dirOnly && !dir {
+				continue
+			}
+			if rule.re.MatchString(sub) {
+				return !rule.negate
+			}
+		}
+	}
+	return false
+}
+
+// ignoreCache remembers the rules in effect in each directory already listed, so that its
+// subdirectories can inherit them.
+type ignoreCache struct {
+	mu    sync.Mutex
+	rules map[string]*ignoreRules
+}
+
+// ignoreRules returns the rules in effect within the directory at path, reading any ignore
+// files it holds. Its parent must already have been listed, unless it is the root.
+func (w *walker) ignoreRules(path string) (*ignoreRules, error) {
+	if len(w.opts.IgnoreFiles) == 0 {
+		return nil, nil
+	}
+
+	var parent *ignoreRules
+	if path != w.root {
+		w.ignores.mu.Lock()
+		parent = w.ignores.rules[w.parentDir(path)]
+		w.ignores.mu.Unlock()
+	}
+
+	rel := ""
+	if path != w.root {
+		rel = strings.TrimPrefix(path, w.root+"/")
+	}
+	rules := &ignoreRules{parent: parent, base: rel}
+	for _, name := range w.opts.IgnoreFiles {
+		parsed, err := w.readIgnoreFile(w.join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		rules.rules = append(rules.rules, parsed...)
+	}
+	if len(rules.rules) == 0 {
+		rules = parent
+	}
+
+	w.ignores.mu.Lock()
+	w.ignores.rules[path] = rules
+	w.ignores.mu.Unlock()
+	return rules, nil
+}
+
+// parentDir returns the directory containing path, as it was joined by w.join.
+func (w *walker) parentDir(p string) string {
+	if w.fsys != nil {
+		return path.Dir(p)
+	}
+	return p[:strings.LastIndex(p, "/")]
+}
+
+// readIgnoreFile parses the ignore file at path, which needn't exist.
+func (w *walker) readIgnoreFile(path string) ([]ignoreRule, error) {
+	file, err := w.open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseIgnoreLine parses one line of an ignore file, following the rules of gitignore(5). It
+// reports false for blank lines and comments.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are dropped unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// A pattern with a slash anywhere but the end is anchored to the ignore file's directory,
+	// while one without matches at any depth beneath it
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	prefix := "^"
+	if !anchored {
+		prefix = "^(?:.*/)?"
+	}
+	re, err := regexp.Compile(prefix + ignoreGlobToRegexp(line) + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// ignoreGlobToRegexp translates a gitignore glob into an equivalent regular expression. As in
+// git, '*' and '?' never match a slash, while "**" matches across directories when it makes up
+// a whole path component.
+func ignoreGlobToRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
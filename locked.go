@@ -0,0 +1,63 @@
+package dirhash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrFileLocked is the underlying error when a file can't be read because another process holds
+// it open without sharing it, or has locked part of it, as databases and some logs do on Windows.
+var ErrFileLocked = errors.New("file is locked by another process")
+
+// LockedPolicy says what to do about files which are locked by another process, so that they
+// can't be read however they are opened.
+type LockedPolicy int
+
+const (
+	// LockedAsError, the default, treats a locked file like any other file which can't be read,
+	// stopping or skipping according to OnError.
+	LockedAsError LockedPolicy = iota
+
+	// LockedSkip always skips a locked file, even under ErrorFailFast, listing it in its
+	// directory's pseudo-file with "!" in place of its hash as ErrorSkip does, and reporting it
+	// to OnSkip. Under ErrorCollect it is also listed in the *SkippedError.
+	LockedSkip
+
+	// LockedFail always abandons the hash at a locked file, even under ErrorSkip or
+	// ErrorCollect, for a hash which must cover every file or none.
+	LockedFail
+)
+
+var lockedPolicyNames = map[LockedPolicy]string{
+	LockedAsError: "error",
+	LockedSkip:    "skip",
+	LockedFail:    "fail",
+}
+
+// ParseLockedPolicy returns the policy with the given name, as returned by LockedPolicy.String.
+func ParseLockedPolicy(name string) (LockedPolicy, error) {
+	for p, n := range lockedPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown locked file policy %q", name)
+}
+
+// String returns the short lowercase name of the policy, such as "skip".
+func (p LockedPolicy) String() string {
+	if name, ok := lockedPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("LockedPolicy(%d)", int(p))
+}
+
+// lockedError makes err wrap ErrFileLocked as well, if it failed because the file was locked.
+func lockedError(err error) error {
+	var pathErr *os.PathError
+	if !isLocked(err) || errors.Is(err, ErrFileLocked) || !errors.As(err, &pathErr) {
+		return err
+	}
+	return &os.PathError{Op: pathErr.Op, Path: pathErr.Path, Err: fmt.Errorf("%w: %w", ErrFileLocked, pathErr.Err)}
+}
@@ -0,0 +1,86 @@
+package dirhash
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The errors Windows gives for a file which another process holds open without sharing it, and
+// for a read of part of a file which another process has locked.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLocked reports whether err came of a file being locked by another process.
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
+
+// openDisk opens the file at path on disk for reading. Unlike os.Open, it lets other processes
+// go on to delete or rename the file, and so can open files held open by a process which
+// insists on that, as many logs and databases do. It opens them with backup semantics, which
+// let a process holding SeBackupPrivilege read files its permissions wouldn't otherwise let it;
+// the privilege is enabled the first time, for a process which holds it at all, as an elevated
+// administrator does.
+func openDisk(path string) (*os.File, error) {
+	return openExtended(path, extendedPath(path))
+}
+
+// openExtended opens the file at path, given in its extended-length form as extended, as
+// openDisk does.
+func openExtended(path, extended string) (*os.File, error) {
+	backupPrivilege.Do(enableBackupPrivilege)
+	name, err := syscall.UTF16PtrFromString(extended)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+var backupPrivilege sync.Once
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procLookupPrivilegeValueW = advapi32.NewProc("LookupPrivilegeValueW")
+	procAdjustTokenPrivileges = advapi32.NewProc("AdjustTokenPrivileges")
+)
+
+// tokenPrivileges is TOKEN_PRIVILEGES holding a single privilege.
+type tokenPrivileges struct {
+	count      uint32
+	luid       [2]uint32
+	attributes uint32
+}
+
+const sePrivilegeEnabled = 2
+
+// enableBackupPrivilege enables SeBackupPrivilege for the process, if it holds it, so that
+// files opened with backup semantics can be read whatever their permissions. Nothing changes
+// for a process which doesn't hold it.
+func enableBackupPrivilege() {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+	var token syscall.Token
+	if err := syscall.OpenProcessToken(process, syscall.TOKEN_ADJUST_PRIVILEGES|syscall.TOKEN_QUERY, &token); err != nil {
+		return
+	}
+	defer token.Close()
+
+	name, _ := syscall.UTF16PtrFromString("SeBackupPrivilege")
+	privileges := tokenPrivileges{count: 1, attributes: sePrivilegeEnabled}
+	if ok, _, _ := procLookupPrivilegeValueW.Call(0, uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&privileges.luid))); ok == 0 {
+		return
+	}
+	procAdjustTokenPrivileges.Call(uintptr(token), 0, uintptr(unsafe.Pointer(&privileges)), 0, 0, 0)
+}
@@ -0,0 +1,42 @@
+package dirhash
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// logEnabled reports whether the Logger, if there is one, records messages at level, so that
+// anything costly to log can be left out otherwise.
+func (w *walker) logEnabled(level slog.Level) bool {
+	return w.opts.Logger != nil && w.opts.Logger.Enabled(w.ctx, level)
+}
+
+// logDir logs that the directory at path has been hashed. Its pseudo-file is logged as well
+// if it was recorded, which is only done at the debug level.
+func (w *walker) logDir(path string, hash []byte, pseudoFile *strings.Builder) {
+	if !w.logEnabled(slog.LevelInfo) {
+		return
+	}
+	if pseudoFile == nil {
+		w.opts.Logger.InfoContext(w.ctx, "hashed directory", "path", w.display(path), "hash", fmt.Sprintf("%X", hash))
+		return
+	}
+	w.opts.Logger.DebugContext(w.ctx, "hashed directory", "path", w.display(path), "hash", fmt.Sprintf("%X", hash), "pseudofile", pseudoFile.String())
+}
+
+// logFile logs that the file at path has been hashed.
+func (w *walker) logFile(path string, size int64, hash []byte) {
+	if !w.logEnabled(slog.LevelDebug) {
+		return
+	}
+	w.opts.Logger.DebugContext(w.ctx, "hashed file", "path", w.display(path), "size", size, "hash", fmt.Sprintf("%X", hash))
+}
+
+// logSkip logs that the entry at path was skipped because of err.
+func (w *walker) logSkip(path string, err error) {
+	if !w.logEnabled(slog.LevelWarn) {
+		return
+	}
+	w.opts.Logger.WarnContext(w.ctx, "skipped entry", "path", w.display(path), "error", err)
+}
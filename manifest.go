@@ -0,0 +1,647 @@
+package dirhash
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A manifest lists the hashes of individual files, one per line, in the format produced and
+// checked by 'sha256sum'. Lines beginning with '#' are comments, and are ignored along with any
+// blank lines, except that those written by WriteManifest carry some extra information:
+//
+//	# dirhash algorithm=sha256
+//	1843653496800edfd0d30326c82f53b0338ed408468cca4a2f1b52f2f6395fc9  a/b.txt
+//	...
+//	# dirhash footer sha256=<footer hash> root=<root hash>
+//
+// The header names the algorithm the hashes were computed with, and every hash in the manifest
+// must be the right length for it; manifests without a header are taken to hold SHA256 hashes.
+//
+// Manifests written with Options.ChunkSize set give the chunk size on a second header line, and
+// follow the entry of each file hashed in several chunks with the hash of every chunk in turn:
+//
+//	# dirhash chunksize=1073741824
+//	...
+//	<hash of the whole file>  disk.img
+//	# dirhash chunk <hash of the first chunk>
+//	# dirhash chunk <hash of the second chunk>
+//	...
+//
+// The chunk hashes are in lowercase hexadecimal like any other. Since they are only comments,
+// 'sha256sum' reads past them, but it can't check the files hashed in chunks, which no longer
+// have the hash of their contents.
+//
+// The footer is only present in manifests written with Options.SelfHashedManifest set, which
+// also say so in a header line of their own after any others, so that a manifest cut short
+// before its footer can still be told from one which never had one:
+//
+//	# dirhash self-hashed
+//
+// The footer hash is the SHA256, in lowercase hexadecimal, of every byte of the manifest which
+// precedes the footer line, from the start of the header up to and including the newline ending
+// the last entry; the root hash is the hash of the whole directory, in capitalized hexadecimal
+// as printed by the command line tool. Nothing may follow the footer.
+//
+// Manifests written with Options.ZeroTerminated set end every line, header and footer included,
+// with a NUL byte in place of the newline, and never escape names. Whichever of the two ends the
+// first line of a manifest is taken to end all the others.
+
+const (
+	manifestHeaderPrefix    = "# dirhash algorithm="
+	manifestChunkSizePrefix = "# dirhash chunksize="
+	manifestChunkPrefix     = "# dirhash chunk "
+	manifestFooterPrefix    = "# dirhash footer "
+	manifestSelfHashed      = "# dirhash self-hashed"
+)
+
+// ErrManifestIntegrity is returned when a manifest's footer doesn't match the rest of it.
+var ErrManifestIntegrity = errors.New("manifest does not match its integrity footer")
+
+// ErrNoManifestFooter is returned when a manifest which should end in a footer doesn't, as is
+// the case if it was truncated.
+var ErrNoManifestFooter = errors.New("manifest has no integrity footer")
+
+// WriteManifest writes a manifest of every file in the directory at path to w.
+func WriteManifest(path string, w io.Writer) error {
+	return WriteManifestWithOptions(path, w, Options{})
+}
+
+// WriteManifestWithOptions writes a manifest of every file in the directory at path to w, with
+// the hashes computed according to opts. Entries are written as soon as each file is hashed,
+// and their paths are given relative to path.
+func WriteManifestWithOptions(path string, w io.Writer, opts Options) error {
+	// Tee everything written into a hash, in case there's to be a footer
+	footerHash := sha256.New()
+	out := io.MultiWriter(w, footerHash)
+	algorithm := opts.Algorithm.String()
+	if opts.NewHash != nil {
+		algorithm = "custom"
+	}
+	end := "\n"
+	if opts.ZeroTerminated {
+		end = "\x00"
+	}
+	if _, err := io.WriteString(out, manifestHeaderPrefix+algorithm+end); err != nil {
+		return err
+	}
+	if opts.ChunkSize > 0 && !opts.ShellSortCompat {
+		if _, err := fmt.Fprintf(out, "%s%d%s", manifestChunkSizePrefix, opts.ChunkSize, end); err != nil {
+			return err
+		}
+	}
+	if opts.SelfHashedManifest {
+		if _, err := io.WriteString(out, manifestSelfHashed+end); err != nil {
+			return err
+		}
+	}
+
+	// Write out each file as it is hashed, holding on to the first error to turn up
+	var mu sync.Mutex
+	var writeErr error
+	onFile := opts.OnFile
+	opts.OnFile = func(e Entry) {
+		mu.Lock()
+		if writeErr == nil {
+			name := relativePath(path, &opts, e.Path)
+			var line string
+			if opts.ZeroTerminated {
+				line = hex.EncodeToString(e.Sum) + "  " + name + end
+			} else {
+				line = sumLine(e.Sum, name)
+			}
+			for _, chunk := range e.Chunks {
+				line += manifestChunkPrefix + hex.EncodeToString(chunk) + end
+			}
+			_, writeErr = io.WriteString(out, line)
+		}
+		mu.Unlock()
+		if onFile != nil {
+			onFile(e)
+		}
+	}
+
+	root, err := HashDirWithOptions(path, opts)
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	if opts.SelfHashedManifest {
+		footer := fmt.Sprintf("%ssha256=%x root=%X%s", manifestFooterPrefix, footerHash.Sum(nil), root, end)
+		if _, err := io.WriteString(w, footer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyManifestIntegrity reads a manifest written with Options.SelfHashedManifest from r and
+// checks it against its footer, returning ErrNoManifestFooter if the manifest has been cut short
+// or ErrManifestIntegrity if it has otherwise been damaged. If the manifest is intact, the root
+// hash recorded in the footer is returned.
+func VerifyManifestIntegrity(r io.Reader) ([]byte, error) {
+	footerHash := sha256.New()
+	splitter := newManifestSplitter()
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitter.split)
+	var footer string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if footer != "" {
+			return nil, ErrManifestIntegrity // Something follows the footer
+		}
+		if strings.HasPrefix(line, manifestFooterPrefix) {
+			footer = line
+		} else {
+			footerHash.Write([]byte(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if footer == "" || !strings.HasSuffix(footer, splitter.terminators) {
+		return nil, ErrNoManifestFooter
+	}
+
+	// Pull the two hashes out of the footer and check the first against what we've just read
+	var manifestHex, rootHex string
+	footer = strings.TrimSuffix(footer[len(manifestFooterPrefix):], splitter.terminators)
+	if _, err := fmt.Sscanf(footer, "sha256=%s root=%s", &manifestHex, &rootHex); err != nil {
+		return nil, ErrManifestIntegrity
+	}
+	if manifestHex != hex.EncodeToString(footerHash.Sum(nil)) {
+		return nil, ErrManifestIntegrity
+	}
+	root, err := hex.DecodeString(rootHex)
+	if err != nil {
+		return nil, ErrManifestIntegrity
+	}
+	return root, nil
+}
+
+// IsSelfHashedManifest reports whether the manifest read from r was written with
+// Options.SelfHashedManifest set, going by its header or its footer, so that it should be
+// checked with VerifyManifestIntegrity before it is trusted.
+func IsSelfHashedManifest(r io.Reader) (bool, error) {
+	splitter := newManifestSplitter()
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitter.split)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n\x00")
+		if line == manifestSelfHashed || strings.HasPrefix(line, manifestFooterPrefix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// VerifyResult reports the outcome of checking a single file listed in a manifest.
+type VerifyResult struct {
+	Line int    // The line of the manifest which listed the file
+	Path string // The path of the file, relative to the root being verified
+	OK   bool   // Whether the file's contents still match the listed hash
+	Err  error  // Any error which prevented the entry from being checked at all
+
+	// BadChunks lists the chunks of a file which no longer match, by their index from the
+	// start of the file, if the manifest gives the hashes of its chunks.
+	BadChunks []int
+}
+
+// VerifyStream checks the files under root against the manifest read from r, using whichever
+// algorithm and chunk size the manifest's header names, or SHA256 if it has none. The manifest is parsed
+// incrementally and a result is sent on the returned channel for each entry as soon as it has
+// been checked, so even enormous manifests are verified in constant memory. The channel is
+// closed once the manifest has been read to the end, and the caller must drain it. An entry
+// whose path is absolute, or leads out of root by way of "..", is never opened, and is reported
+// as malformed instead.
+func VerifyStream(root string, r io.Reader) (<-chan VerifyResult, error) {
+	// Make sure there's a directory to verify before we start
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+
+	results := make(chan VerifyResult)
+	go func() {
+		defer close(results)
+		m := newManifestReader(r)
+		for {
+			entry, err := m.next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				results <- VerifyResult{Line: m.line, Err: err}
+				if _, ok := err.(*manifestSyntaxError); ok {
+					continue
+				}
+				return
+			}
+
+			if !withinRoot(entry.path) {
+				results <- VerifyResult{Line: entry.line, Path: entry.path, Err: &manifestSyntaxError{entry.line, "path outside the root"}}
+				continue
+			}
+			hash, chunks, err := hashFileWith(m.algorithm, m.chunkSize, root+"/"+entry.path)
+			result := VerifyResult{Line: entry.line, Path: entry.path, OK: err == nil && bytes.Equal(hash, entry.sum), Err: err}
+			if !result.OK && err == nil && entry.chunks != nil {
+				result.BadChunks = badChunks(entry.chunks, chunks)
+			}
+			results <- result
+		}
+	}()
+	return results, nil
+}
+
+// withinRoot reports whether the slash-separated path listed in a manifest stays beneath the
+// root it is relative to.
+func withinRoot(name string) bool {
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return false
+	}
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+	return clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// ManifestDiff lists the files which differ between a manifest and a directory, or between two
+// directories or two manifests. Paths are relative to the root, and each list is sorted.
+type ManifestDiff struct {
+	Modified []string // Files whose contents no longer match their listed hash
+	Added    []string // Files which aren't listed at all
+	Removed  []string // Files which are listed but no longer exist
+
+	// BadChunks lists the chunks which no longer match of each modified file whose chunks
+	// are known on both sides, by their index from the start of the file.
+	BadChunks map[string][]int
+}
+
+// OK reports whether there are no differences at all.
+func (d *ManifestDiff) OK() bool {
+	return len(d.Modified) == 0 && len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// VerifyManifest hashes the directory at path and compares every file in it against the
+// manifest read from r, using whichever algorithm the manifest names. Unlike VerifyStream, it
+// notices files which have been added as well as those which have changed or gone missing.
+func VerifyManifest(path string, r io.Reader) (*ManifestDiff, error) {
+	return VerifyManifestWithOptions(path, r, Options{})
+}
+
+// VerifyManifestWithOptions is like VerifyManifest, but hashes the directory according to opts,
+// which should select the same files as when the manifest was written. The algorithm and chunk
+// size are always taken from the manifest.
+func VerifyManifestWithOptions(path string, r io.Reader, opts Options) (*ManifestDiff, error) {
+	manifest, err := ReadManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	opts.Algorithm, opts.NewHash, opts.ChunkSize = manifest.Algorithm, nil, manifest.ChunkSize
+
+	// Hold on to the chunks of each file, to tell which of them changed
+	var mu sync.Mutex
+	chunks := make(map[string][][]byte)
+	onFile := opts.OnFile
+	opts.OnFile = func(e Entry) {
+		if e.Chunks != nil {
+			mu.Lock()
+			chunks[relativePath(path, &opts, e.Path)] = e.Chunks
+			mu.Unlock()
+		}
+		if onFile != nil {
+			onFile(e)
+		}
+	}
+	_, files, err := collectFiles(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff ManifestDiff
+	diff.Modified, diff.Added, diff.Removed = diffFiles(manifest.Files, files)
+	diff.BadChunks = diffChunks(diff.Modified, manifest.Chunks, chunks)
+	return &diff, nil
+}
+
+// sumEntry is a single file listed in a manifest.
+type sumEntry struct {
+	path   string
+	sum    []byte
+	chunks [][]byte // The hashes of the file's chunks, if the manifest lists them
+	line   int      // The line which listed the file
+}
+
+// manifestSplitter splits a manifest into lines for a bufio.Scanner, keeping the byte which ends
+// each one. Until the first line has been seen, either a newline or a NUL may end it, and from
+// then on only whichever did.
+type manifestSplitter struct {
+	terminators string
+}
+
+func newManifestSplitter() *manifestSplitter {
+	return &manifestSplitter{terminators: "\n\x00"}
+}
+
+func (s *manifestSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, s.terminators); i >= 0 {
+		s.terminators = string(data[i])
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// manifestReader parses a manifest one line at a time.
+type manifestReader struct {
+	scanner    *bufio.Scanner
+	splitter   *manifestSplitter
+	line       int
+	algorithm  Algorithm // As declared by the header, if there was one
+	digestSize int       // The size of the algorithm's hashes, which every entry must match
+	chunkSize  int64     // As declared by the header, if there was one
+
+	// An entry isn't returned until the line after it has been read, in case its chunks follow
+	// it, and any error on that line waits until the entry is returned.
+	pending *sumEntry
+	err     error
+}
+
+func newManifestReader(r io.Reader) *manifestReader {
+	m := &manifestReader{scanner: bufio.NewScanner(r), splitter: newManifestSplitter(), digestSize: AlgorithmSHA256.DigestSize()}
+	m.scanner.Split(m.splitter.split)
+	return m
+}
+
+// manifestSyntaxError reports a malformed line, after which parsing may carry on.
+type manifestSyntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *manifestSyntaxError) Error() string {
+	return fmt.Sprintf("manifest line %d: %s", e.line, e.msg)
+}
+
+// next returns the next entry in the manifest, or io.EOF once there are none left.
+func (m *manifestReader) next() (sumEntry, error) {
+	if m.err != nil {
+		err := m.err
+		m.err = nil
+		return sumEntry{}, err
+	}
+	for {
+		entry, err := m.nextLine()
+		if err != nil && m.pending != nil {
+			// The entry before is complete, whatever went wrong after it
+			if err != io.EOF {
+				m.err = err
+			}
+			return m.takePending(), nil
+		}
+		if err != nil {
+			return sumEntry{}, err
+		}
+		if m.pending != nil {
+			prev := m.takePending()
+			m.pending = &entry
+			return prev, nil
+		}
+		m.pending = &entry
+	}
+}
+
+// takePending returns the entry waiting to be returned, and forgets it.
+func (m *manifestReader) takePending() sumEntry {
+	entry := *m.pending
+	m.pending = nil
+	return entry
+}
+
+// nextLine reads up to the next line listing a file and returns its entry, taking note of any
+// header lines along the way, and adding any chunks to the pending entry.
+func (m *manifestReader) nextLine() (sumEntry, error) {
+	for m.scanner.Scan() {
+		m.line++
+		line := m.scanner.Text()
+		if m.zeroTerminated() {
+			line = strings.TrimSuffix(line, "\x00")
+		} else {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		}
+		switch {
+		case strings.HasPrefix(line, manifestHeaderPrefix):
+			algorithm, err := ParseAlgorithm(line[len(manifestHeaderPrefix):])
+			if err != nil {
+				return sumEntry{}, &manifestSyntaxError{m.line, err.Error()}
+			}
+			m.algorithm = algorithm
+			m.digestSize = algorithm.DigestSize()
+			continue
+		case strings.HasPrefix(line, manifestChunkSizePrefix):
+			size, err := strconv.ParseInt(line[len(manifestChunkSizePrefix):], 10, 64)
+			if err != nil || size <= 0 {
+				return sumEntry{}, &manifestSyntaxError{m.line, "invalid chunk size"}
+			}
+			m.chunkSize = size
+			continue
+		case strings.HasPrefix(line, manifestChunkPrefix):
+			if err := m.parseChunk(line[len(manifestChunkPrefix):]); err != nil {
+				return sumEntry{}, err
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return m.parse(line)
+	}
+	if err := m.scanner.Err(); err != nil {
+		return sumEntry{}, err
+	}
+	return sumEntry{}, io.EOF
+}
+
+// parseChunk decodes the hash of a chunk, and adds it to the chunks of the pending entry.
+func (m *manifestReader) parseChunk(x string) error {
+	if m.pending == nil || m.chunkSize == 0 {
+		return &manifestSyntaxError{m.line, "chunk hash without a chunked file before it"}
+	}
+	sum, err := hex.DecodeString(x)
+	if err != nil || len(sum) != m.digestSize {
+		return &manifestSyntaxError{m.line, "invalid chunk hash"}
+	}
+	m.pending.chunks = append(m.pending.chunks, sum)
+	return nil
+}
+
+// zeroTerminated reports whether the manifest's lines end in NULs, in which case names aren't
+// escaped.
+func (m *manifestReader) zeroTerminated() bool {
+	return m.splitter.terminators == "\x00"
+}
+
+// parse decodes a line of the form "<hex>  <name>", or "<hex> *<name>" for files which were
+// hashed in binary mode, undoing the escaping described in sumLine.
+func (m *manifestReader) parse(line string) (sumEntry, error) {
+	escaped := strings.HasPrefix(line, "\\") && !m.zeroTerminated()
+	if escaped {
+		line = line[1:]
+	}
+
+	i := strings.IndexByte(line, ' ')
+	if i < 0 || i+2 > len(line) || (line[i+1] != ' ' && line[i+1] != '*') {
+		return sumEntry{}, &manifestSyntaxError{m.line, "expected a hash followed by a filename"}
+	}
+	sum, err := hex.DecodeString(line[:i])
+	if err != nil || len(sum) == 0 {
+		return sumEntry{}, &manifestSyntaxError{m.line, "invalid hexadecimal hash"}
+	}
+	if len(sum) != m.digestSize {
+		msg := fmt.Sprintf("hash is %d hex digits long, but %s hashes are %d", i, m.algorithm, 2*m.digestSize)
+		return sumEntry{}, &manifestSyntaxError{m.line, msg}
+	}
+
+	name := line[i+2:]
+	if escaped {
+		name = unescapeSumName(name)
+	}
+	if name == "" {
+		return sumEntry{}, &manifestSyntaxError{m.line, "missing filename"}
+	}
+	return sumEntry{path: name, sum: sum, line: m.line}, nil
+}
+
+func unescapeSumName(x string) string {
+	return strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r").Replace(x)
+}
+
+// hashFileWith hashes the contents of the file at path with the given algorithm and chunk size,
+// returning the hashes of its chunks as well if it has several.
+func hashFileWith(algorithm Algorithm, chunkSize int64, path string) ([]byte, [][]byte, error) {
+	w := newWalker(&Options{Algorithm: algorithm, ChunkSize: chunkSize})
+	hash, err := w.hashContents(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hash, w.takeChunks(path), nil
+}
+
+// Manifest is a manifest held in memory.
+type Manifest struct {
+	Algorithm Algorithm         // The algorithm the hashes were computed with
+	ChunkSize int64             // The chunk size large files were hashed with, or zero if none
+	Files     map[string][]byte // The hash of each file, keyed by its path relative to the root
+
+	// Chunks holds the hashes of the chunks of each file which was hashed in several chunks,
+	// keyed by its path relative to the root, as they are needed by VerifyChunks.
+	Chunks map[string][][]byte
+}
+
+// ReadManifest reads an entire manifest from r.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Files: make(map[string][]byte), Chunks: make(map[string][][]byte)}
+	m := newManifestReader(r)
+	for {
+		entry, err := m.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files[entry.path] = entry.sum
+		if entry.chunks != nil {
+			manifest.Chunks[entry.path] = entry.chunks
+		}
+	}
+	manifest.Algorithm = m.algorithm
+	manifest.ChunkSize = m.chunkSize
+	return manifest, nil
+}
+
+// DiffManifests compares two manifests read earlier with ReadManifest, without needing either
+// of the directories they describe, and lists the files which differ between them as for
+// VerifyManifest: Added files are only in newer, and Removed files only in older. Both must
+// have been written with the same algorithm, since hashes from different algorithms can't be
+// compared.
+func DiffManifests(older, newer *Manifest) (*ManifestDiff, error) {
+	if older.Algorithm != newer.Algorithm {
+		return nil, fmt.Errorf("cannot compare a %s manifest with a %s manifest", older.Algorithm, newer.Algorithm)
+	}
+	var diff ManifestDiff
+	diff.Modified, diff.Added, diff.Removed = diffFiles(older.Files, newer.Files)
+	if older.ChunkSize == newer.ChunkSize {
+		diff.BadChunks = diffChunks(diff.Modified, older.Chunks, newer.Chunks)
+	}
+	return &diff, nil
+}
+
+// collectFiles hashes the directory at path, returning its hash together with the hash of
+// every file beneath it, keyed by the file's path relative to the directory.
+func collectFiles(path string, opts Options) ([]byte, map[string][]byte, error) {
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	onFile := opts.OnFile
+	opts.OnFile = func(e Entry) {
+		mu.Lock()
+		files[relativePath(path, &opts, e.Path)] = e.Sum
+		mu.Unlock()
+		if onFile != nil {
+			onFile(e)
+		}
+	}
+
+	root, err := HashDirWithOptions(path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, files, nil
+}
+
+// diffFiles compares two sets of file hashes, returning the sorted paths of the files which
+// differ between them, which are only in newer, and which are only in older.
+func diffFiles(older, newer map[string][]byte) (changed, added, removed []string) {
+	for path, sum := range newer {
+		oldSum, ok := older[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case !bytes.Equal(oldSum, sum):
+			changed = append(changed, path)
+		}
+	}
+	for path := range older {
+		if _, ok := newer[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(added)
+	sort.Strings(removed)
+	return changed, added, removed
+}
+
+// relativePath returns the path of an Entry relative to the root it was hashed from.
+func relativePath(root string, opts *Options, path string) string {
+	if opts.RelativeErrors {
+		return path // Already relative
+	}
+	return strings.TrimPrefix(path, root+"/")
+}
@@ -0,0 +1,146 @@
+package dirhash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestManifestHashLengths checks that every hash in a manifest must be as long as the hashes of
+// the algorithm its header declares, or of SHA256 without a header.
+func TestManifestHashLengths(t *testing.T) {
+	sha1 := strings.Repeat("ab", 20)
+	sha256 := strings.Repeat("ab", 32)
+	sha512 := strings.Repeat("ab", 64)
+
+	for _, test := range []struct {
+		name     string
+		manifest string
+		err      string // What the error says, or empty if there should be none
+	}{
+		{"sha256 header", "# dirhash algorithm=sha256\n" + sha256 + "  a\n", ""},
+		{"sha512 header", "# dirhash algorithm=sha512\n" + sha512 + "  a\n", ""},
+		{"no header", sha256 + "  a\n" + sha256 + "  b\n", ""},
+		{
+			"sha1 hash under sha256",
+			"# dirhash algorithm=sha256\n" + sha256 + "  a\n" + sha1 + "  b\n",
+			"manifest line 3: hash is 40 hex digits long, but sha256 hashes are 64",
+		},
+		{
+			"sha256 hash under sha512",
+			"# dirhash algorithm=sha512\n" + sha256 + "  a\n",
+			"manifest line 2: hash is 64 hex digits long, but sha512 hashes are 128",
+		},
+		{
+			"sha512 hash without a header",
+			sha512 + "  a\n",
+			"manifest line 1: hash is 128 hex digits long, but sha256 hashes are 64",
+		},
+		{
+			"truncated hash",
+			"# dirhash algorithm=sha256\n" + sha256[:62] + "  a\n",
+			"manifest line 2: hash is 62 hex digits long, but sha256 hashes are 64",
+		},
+		{
+			"escaped name",
+			"# dirhash algorithm=sha256\n\\" + sha1 + "  new\\nline\n",
+			"manifest line 2: hash is 40 hex digits long, but sha256 hashes are 64",
+		},
+	} {
+		_, err := ReadManifest(strings.NewReader(test.manifest))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: no error, want %q", test.name, test.err)
+		case test.err != "" && err.Error() != test.err:
+			t.Errorf("%s: error is %q, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestSelfHashedManifest(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "alpha", "b.txt": "beta", "sub/c.txt": "gamma"})
+	var buf bytes.Buffer
+	if err := WriteManifestWithOptions(root, &buf, Options{SelfHashedManifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	manifest := buf.String()
+
+	want, err := HashDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifyManifestIntegrity(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("footer gives root hash %X, want %X", got, want)
+	}
+
+	// Cutting the manifest short anywhere after the header loses the footer, yet leaves the
+	// manifest marked as self-hashed
+	lines := strings.SplitAfter(manifest, "\n")
+	for n := 2; n < len(lines)-1; n++ {
+		truncated := strings.Join(lines[:n], "")
+		if selfHashed, err := IsSelfHashedManifest(strings.NewReader(truncated)); err != nil || !selfHashed {
+			t.Errorf("manifest cut to %d lines isn't self-hashed: %v", n, err)
+		}
+		if _, err := VerifyManifestIntegrity(strings.NewReader(truncated)); !errors.Is(err, ErrNoManifestFooter) {
+			t.Errorf("manifest cut to %d lines returned %v, want ErrNoManifestFooter", n, err)
+		}
+	}
+
+	damaged := strings.Replace(manifest, "a.txt", "A.txt", 1)
+	if _, err := VerifyManifestIntegrity(strings.NewReader(damaged)); !errors.Is(err, ErrManifestIntegrity) {
+		t.Errorf("damaged manifest returned %v, want ErrManifestIntegrity", err)
+	}
+
+	buf.Reset()
+	if err := WriteManifest(root, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if selfHashed, err := IsSelfHashedManifest(&buf); err != nil || selfHashed {
+		t.Errorf("plain manifest is self-hashed: %t, %v", selfHashed, err)
+	}
+}
+
+// TestVerifyStreamOutsideRoot checks that VerifyStream refuses to look at anything a manifest
+// lists outside the root, however well its hash would match.
+func TestVerifyStreamOutsideRoot(t *testing.T) {
+	parent := makeTree(t, map[string]string{"secret": "s", "root/a.txt": "a", "root/sub/b.txt": "b"})
+	root := filepath.Join(parent, "root")
+	secret, err := HashFile(filepath.Join(parent, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := HashFile(filepath.Join(root, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "%x  a.txt\n", a)
+	fmt.Fprintf(&manifest, "%x  sub/../a.txt\n", a)
+	fmt.Fprintf(&manifest, "%x  ../secret\n", secret)
+	fmt.Fprintf(&manifest, "%x  sub/../../secret\n", secret)
+	fmt.Fprintf(&manifest, "%x  %s\n", secret, filepath.ToSlash(filepath.Join(parent, "secret")))
+
+	results, err := VerifyStream(root, strings.NewReader(manifest.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside := map[string]bool{"a.txt": true, "sub/../a.txt": true}
+	for result := range results {
+		switch {
+		case inside[result.Path] && !result.OK:
+			t.Errorf("%s: failed to verify: %v", result.Path, result.Err)
+		case !inside[result.Path] && (result.OK || result.Err == nil):
+			t.Errorf("%s: verified a path outside the root", result.Path)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package dirhash
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Metadata selects which metadata of each file and directory is recorded in the hash, in
+// addition to names and contents.
+type Metadata int
+
+const (
+	// MetadataMode records the permission bits, along with the setuid, setgid, and sticky bits.
+	MetadataMode Metadata = 1 << iota
+
+	// MetadataOwner records the numeric user and group IDs of the owner. They only exist on
+	// Unix systems, and elsewhere are silently left out.
+	MetadataOwner
+
+	// MetadataMtime records the modification time, to the nanosecond.
+	MetadataMtime
+
+	// MetadataLinks records which files are hard links to the same contents, so that turning
+	// hard links into copies, or copies into hard links, changes the hash. Each file with more
+	// than one link is given its number of links, including any from outside the tree, and the
+	// path relative to the root of the first file in the tree, in order of path, which shares
+	// its inode, possibly itself:
+	//
+	//	8E35C2CD3BF6641BDB0E2050B76932CBB2E6034A0DDACC1D9BEA82A6BA57F7CF "b" nlink=2 link="a/b"
+	//
+	// Directories are left alone. Link counts only exist on Unix systems, and elsewhere are
+	// silently left out. Finding the links takes a walk over the tree before it is hashed.
+	MetadataLinks
+)
+
+var metadataNames = []struct {
+	m    Metadata
+	name string
+}{
+	{MetadataMode, "mode"},
+	{MetadataOwner, "owner"},
+	{MetadataMtime, "mtime"},
+	{MetadataLinks, "links"},
+}
+
+// ParseMetadata parses a comma-separated list of metadata names, as returned by
+// Metadata.String, such as "mode,owner".
+func ParseMetadata(s string) (Metadata, error) {
+	var m Metadata
+	if s == "" {
+		return 0, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, n := range metadataNames {
+			if n.name == name {
+				m |= n.m
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown metadata %q", name)
+		}
+	}
+	return m, nil
+}
+
+// String lists the names of the selected metadata, separated by commas.
+func (m Metadata) String() string {
+	var names []string
+	for _, n := range metadataNames {
+		if m&n.m != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// metadataVersion is the version of the pseudo-file format which records metadata. It is
+// written at the top of every pseudo-file which does, so that such hashes can never collide
+// with those of the original format, and so that the format can change again later.
+const metadataVersion = 2
+
+// metadataHeader returns the line which begins each pseudo-file when metadata is recorded.
+func (w *walker) metadataHeader() string {
+	if w.opts.Metadata == 0 {
+		return ""
+	}
+	return fmt.Sprintf("version=%d metadata=%s\n", metadataVersion, w.opts.Metadata)
+}
+
+// metadataAttributes returns the attributes recording the chosen metadata of the entry
+// described by info, to be written after its name.
+func (w *walker) metadataAttributes(info os.FileInfo) string {
+	var attrs string
+	if w.opts.Metadata&MetadataMode != 0 {
+		mode := uint32(info.Mode().Perm())
+		if info.Mode()&os.ModeSetuid != 0 {
+			mode |= 04000
+		}
+		if info.Mode()&os.ModeSetgid != 0 {
+			mode |= 02000
+		}
+		if info.Mode()&os.ModeSticky != 0 {
+			mode |= 01000
+		}
+		attrs += fmt.Sprintf(" mode=%04o", mode)
+	}
+	if w.opts.Metadata&MetadataOwner != 0 {
+		if uid, gid, ok := fileOwner(info); ok {
+			attrs += fmt.Sprintf(" uid=%d gid=%d", uid, gid)
+		}
+	}
+	if w.opts.Metadata&MetadataMtime != 0 {
+		mtime := info.ModTime()
+		attrs += fmt.Sprintf(" mtime=%d.%09d", mtime.Unix(), mtime.Nanosecond())
+	}
+	return attrs
+}
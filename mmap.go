@@ -0,0 +1,97 @@
+package dirhash
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"runtime/debug"
+)
+
+// mmapPiece is how much of a memory-mapped file is handed to the hash at once, between checks
+// of the context.
+const mmapPiece = 4 << 20
+
+// errMappedFault is the underlying error when reading a memory-mapped file faults, as it does
+// if the file is truncated while it is being hashed, or the disk fails to read a page of it.
+var errMappedFault = errors.New("memory-mapped file could not be read, or was truncated while being read")
+
+// mapContents maps file, opened from path, into memory if it is large enough under
+// MmapThreshold, returning a reader for the mapped contents and the function which unmaps them.
+// Only regular files on the local disk are mapped, and not those opened for ReadRetries; if
+// anything else is opened, or the mapping fails, the file is read as usual.
+func (w *walker) mapContents(path string, file fs.File) (io.Reader, func()) {
+	if w.opts.MmapThreshold <= 0 {
+		return nil, nil
+	}
+	f, ok := diskFile(file)
+	if !ok {
+		return nil, nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < w.opts.MmapThreshold {
+		return nil, nil
+	}
+	data, unmap, err := mapFile(f, info.Size())
+	if err != nil {
+		return nil, nil
+	}
+	return &mappedReader{ctx: w.ctx, path: path, data: data}, func() { unmap() }
+}
+
+// mappedReader reads the contents of a memory-mapped file. It hands them to a writer straight
+// from the mapping, without copying them through a buffer, a piece at a time so that a context
+// which is done is noticed. A fault while reading the mapping is returned as an error rather
+// than crashing the program.
+type mappedReader struct {
+	ctx  context.Context
+	path string
+	data []byte
+	off  int
+}
+
+func (r *mappedReader) Read(p []byte) (n int, err error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.off >= len(r.data) {
+		return 0, io.EOF
+	}
+	defer r.recoverFault(&err)
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	n = copy(p, r.data[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func (r *mappedReader) WriteTo(w io.Writer) (written int64, err error) {
+	defer r.recoverFault(&err)
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	for r.off < len(r.data) {
+		if err := r.ctx.Err(); err != nil {
+			return written, err
+		}
+		piece := r.data[r.off:min(r.off+mmapPiece, len(r.data))]
+		n, err := w.Write(piece)
+		r.off += n
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// recoverFault turns a fault while reading the mapping, which SetPanicOnFault makes a panic,
+// into an error in *err. It must be deferred directly by the function reading the mapping.
+func (r *mappedReader) recoverFault(err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if _, fault := p.(interface{ Addr() uintptr }); !fault {
+		panic(p)
+	}
+	*err = &os.PathError{Op: "read", Path: r.path, Err: errMappedFault}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package dirhash
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile always fails, since files are only memory-mapped on Unix systems, and so are read
+// as usual.
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package dirhash
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of file into memory, read-only, returning the mapping along
+// with the function which unmaps it.
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	if int64(int(size)) != size {
+		return nil, nil, errors.New("file too large to map")
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,75 @@
+package dirhash
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HashDirs hashes several directories together into a single hash, such as for a deployment
+// spread over several mount points. The result is the hash of a pseudo-file listing each of the
+// directories by its cleaned path, exactly as though they were the subdirectories of a single
+// directory with those paths for names:
+//
+//	5891B5B522D5DF086D0FF0B110FBD9D21BB4FC7163AF34D08286A2E846F6BE03 "/srv/app"
+//	0CE63AFC1E92EE82744300A778E523B9F42A53FE99201BD39FB8E2DE82965297 "/var/lib/app"
+//	=
+//
+// The order in which the directories are given makes no difference, but naming any of them
+// differently does, so the same paths should be used each time.
+func HashDirs(paths []string) ([]byte, error) {
+	return HashDirsWithOptions(paths, Options{})
+}
+
+// HashDirsWithOptions is like HashDirs, hashing each directory according to opts, and the
+// listing of them all with the same algorithm. Any Stats cover all the directories together.
+func HashDirsWithOptions(paths []string, opts Options) ([]byte, error) {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = filepath.ToSlash(filepath.Clean(path))
+	}
+	sort.Strings(names)
+
+	var total Stats
+	var skipped []error
+	start := time.Now()
+	entries := make([]dirEntry, len(names))
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			return nil, fmt.Errorf("%s: directory given more than once", name)
+		}
+		dirOpts := opts
+		if opts.Stats != nil {
+			dirOpts.Stats = new(Stats)
+		}
+		sum, err := HashDirWithOptions(filepath.FromSlash(name), dirOpts)
+		if s, ok := err.(*SkippedError); ok {
+			skipped, err = append(skipped, s.Errors...), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s := dirOpts.Stats; s != nil {
+			total.Files, total.Dirs, total.Bytes = total.Files+s.Files, total.Dirs+s.Dirs, total.Bytes+s.Bytes
+			total.Skipped += s.Skipped
+		}
+		entries[i] = dirEntry{name: name, dir: true, hash: fmt.Sprintf("%X", sum)}
+	}
+	if opts.Stats != nil {
+		total.Elapsed = time.Since(start)
+		*opts.Stats = total
+	}
+
+	w := newWalker(&opts)
+	header := w.metadataHeader()
+	hash := w.hashPseudoFile(func(out io.Writer) {
+		io.WriteString(out, header)
+		writeEntries(out, entries)
+	})
+	if len(skipped) > 0 {
+		return hash, &SkippedError{Errors: skipped}
+	}
+	return hash, nil
+}
@@ -0,0 +1,81 @@
+package dirhash
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// multihashCodes are the codes by which the multiformats table identifies each algorithm.
+var multihashCodes = map[Algorithm]uint64{
+	AlgorithmSHA256:   0x12,
+	AlgorithmSHA512:   0x13,
+	AlgorithmSHA3_256: 0x16,
+	AlgorithmBLAKE3:   0x1e,
+	AlgorithmXXH64:    0xb3e2,
+}
+
+// rawCodec is the multicodec for a block of raw bytes, which is what the root pseudo-file is.
+const rawCodec = 0x55
+
+// multihash returns sum in multihash form: the code of the algorithm and the length of the
+// digest, each as an unsigned varint, followed by the digest itself.
+func multihash(a Algorithm, sum []byte) ([]byte, error) {
+	code, ok := multihashCodes[a]
+	if !ok {
+		return nil, fmt.Errorf("no multihash code for %s", a)
+	}
+	out := binary.AppendUvarint(nil, code)
+	out = binary.AppendUvarint(out, uint64(len(sum)))
+	return append(out, sum...), nil
+}
+
+// encodeMultihash prints the directory hash as a multihash, in base58btc like IPFS.
+func encodeMultihash(r Result) ([]byte, error) {
+	mh, err := multihash(r.Algorithm, r.Sum)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base58(mh) + "\n"), nil
+}
+
+// encodeCID prints the directory hash as a CIDv1 for the raw bytes of the root pseudo-file,
+// which is exactly what the hash is a hash of, in the usual lowercase base32 multibase form.
+// Under DomainSeparateNodes the bytes hashed begin with the node prefix.
+func encodeCID(r Result) ([]byte, error) {
+	mh, err := multihash(r.Algorithm, r.Sum)
+	if err != nil {
+		return nil, err
+	}
+	cid := binary.AppendUvarint([]byte{1}, rawCodec)
+	cid = append(cid, mh...)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	return []byte("b" + strings.ToLower(encoding.EncodeToString(cid)) + "\n"), nil
+}
+
+// base58Alphabet is the alphabet of base58btc, which leaves out the easily confused 0, O, I,
+// and l.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58 encodes data in base58btc, with each leading zero byte written as a '1'.
+func base58(data []byte) string {
+	var out []byte
+	n := new(big.Int).SetBytes(data)
+	radix, digit := big.NewInt(58), new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, digit)
+		out = append(out, base58Alphabet[digit.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -0,0 +1,181 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HashOCI hashes the filesystem of the container image stored in the OCI image layout at path,
+// such as 'skopeo copy' or recent versions of 'docker save' write, giving the same hash as
+// HashDir would for the root filesystem of a container started from it. The layers are applied
+// one on top of another in memory, with whiteout files hiding what the layers below put there,
+// exactly as the OCI image spec describes. Every blob read is checked against its digest along the way.
+//
+// The layout must hold a single image. Where its index lists several, as for an image built for
+// several platforms, use HashImageLayers with the layers of the one wanted.
+func HashOCI(path string) ([]byte, error) {
+	return HashOCIWithOptions(path, Options{})
+}
+
+// HashOCIWithOptions is like HashOCI, tuned by opts. Only Algorithm, NewHash,
+// DomainSeparateNodes, and Symlinks have any effect, and SymlinkFollowAll isn't supported.
+// Since images commonly hold absolute links, which a running container resolves within its own
+// root but HashDir doesn't, SymlinkHashTarget is the mode to compare a container against.
+func HashOCIWithOptions(path string, opts Options) ([]byte, error) {
+	descriptor, err := ociImageManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Layers []ociDescriptor `json:"layers"`
+	}
+	if err := readOCIBlob(path, descriptor, &manifest); err != nil {
+		return nil, err
+	}
+
+	layers := make([]io.Reader, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		blob, err := openOCIBlob(path, layer)
+		if err != nil {
+			return nil, err
+		}
+		defer blob.Close()
+		layers[i] = blob
+	}
+	return HashImageLayersWithOptions(layers, opts)
+}
+
+// HashImageLayers hashes the filesystem made by applying the given image layers in order, the
+// lowest first, as HashOCI does for the layers of an image. Each layer is a tar archive, which
+// may be compressed with gzip, but not with zstd, as for HashTar.
+func HashImageLayers(layers []io.Reader) ([]byte, error) {
+	return HashImageLayersWithOptions(layers, Options{})
+}
+
+// HashImageLayersWithOptions is like HashImageLayers, tuned by opts as for HashOCIWithOptions.
+func HashImageLayersWithOptions(layers []io.Reader, opts Options) ([]byte, error) {
+	if opts.Symlinks == SymlinkFollowAll {
+		return nil, errors.New("SymlinkFollowAll is not supported for images")
+	}
+	w := newWalker(&opts)
+	tree := newArchiveTree()
+	tree.whiteouts = true
+	for _, layer := range layers {
+		tree.layer++
+		if err := w.readTar(tree, layer); err != nil {
+			return nil, err
+		}
+	}
+	return w.hashArchiveDir(tree.root, tree.root, nil)
+}
+
+// ociDescriptor refers to a blob within an OCI image layout.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// The media types of indexes, which list further manifests rather than layers.
+const (
+	ociIndexType   = "application/vnd.oci.image.index.v1+json"
+	dockerListType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// ociImageManifest finds the manifest of the single image in the layout at path, following any
+// nested indexes down to it.
+func ociImageManifest(path string) (ociDescriptor, error) {
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	data, err := os.ReadFile(filepath.Join(path, "index.json"))
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ociDescriptor{}, fmt.Errorf("%s: %w", filepath.Join(path, "index.json"), err)
+	}
+
+	for {
+		if len(index.Manifests) != 1 {
+			return ociDescriptor{}, fmt.Errorf("%s: image index lists %d manifests, rather than a single image", path, len(index.Manifests))
+		}
+		descriptor := index.Manifests[0]
+		if descriptor.MediaType != ociIndexType && descriptor.MediaType != dockerListType {
+			return descriptor, nil
+		}
+		index.Manifests = nil
+		if err := readOCIBlob(path, descriptor, &index); err != nil {
+			return ociDescriptor{}, err
+		}
+	}
+}
+
+// readOCIBlob decodes the JSON blob with the given descriptor into v.
+func readOCIBlob(path string, descriptor ociDescriptor, v interface{}) error {
+	blob, err := openOCIBlob(path, descriptor)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", descriptor.Digest, err)
+	}
+	return nil
+}
+
+// openOCIBlob opens the blob with the given descriptor for reading, checking it against its
+// digest once it has all been read.
+func openOCIBlob(path string, descriptor ociDescriptor) (io.ReadCloser, error) {
+	algorithm, digest, ok := strings.Cut(descriptor.Digest, ":")
+	if !ok || strings.ContainsAny(algorithm, `/\.`) || strings.ContainsAny(digest, `/\.`) {
+		return nil, fmt.Errorf("malformed digest %q", descriptor.Digest)
+	}
+	var hasher hash.Hash
+	switch algorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return nil, fmt.Errorf("%s: unsupported digest algorithm", descriptor.Digest)
+	}
+
+	file, err := os.Open(filepath.Join(path, "blobs", algorithm, digest))
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedBlob{file: file, hasher: hasher, digest: digest}, nil
+}
+
+// verifiedBlob reads a blob, failing at the end if its contents don't match its digest.
+type verifiedBlob struct {
+	file   *os.File
+	hasher hash.Hash
+	digest string
+}
+
+func (b *verifiedBlob) Read(p []byte) (int, error) {
+	n, err := b.file.Read(p)
+	b.hasher.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(b.hasher.Sum(nil)) != b.digest {
+		return n, fmt.Errorf("%s: blob does not match its digest", b.file.Name())
+	}
+	return n, err
+}
+
+func (b *verifiedBlob) Close() error {
+	return b.file.Close()
+}
@@ -0,0 +1,114 @@
+package dirhash
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// When the process runs out of file descriptors, opening a file is retried this many times,
+// waiting twice as long before each attempt as before the last, in the hope that whatever else
+// the process is doing gives some back.
+const (
+	openRetries      = 8
+	openRetryBackoff = 10 * time.Millisecond
+)
+
+// fileBudget limits the files open at once under MaxOpenFiles.
+type fileBudget struct {
+	slots chan struct{} // One token for each file which is open
+	many  sync.Mutex    // Held while taking several tokens at once
+}
+
+func newFileBudget(max int) *fileBudget {
+	if max == 1 {
+		max = 2 // Comparing duplicates holds two files open at once
+	}
+	return &fileBudget{slots: make(chan struct{}, max)}
+}
+
+// acquireFiles waits until n more files may be opened, returning early with an error if the
+// hash is abandoned in the meantime. Several are taken together under a lock, so that two
+// callers each holding part of what they need can't end up waiting on each other forever.
+func (w *walker) acquireFiles(n int) error {
+	if w.files == nil {
+		return nil
+	}
+	if n > 1 {
+		w.files.many.Lock()
+		defer w.files.many.Unlock()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case w.files.slots <- struct{}{}:
+		case <-w.ctx.Done():
+			w.releaseFiles(i)
+			return w.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// releaseFiles gives back n files taken by acquireFiles.
+func (w *walker) releaseFiles(n int) {
+	if w.files == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		<-w.files.slots
+	}
+}
+
+// openAcquired opens the file at path for reading, once acquireFiles has made room for it. The
+// file gives its room back when it is closed, or straight away if it can't be opened.
+func (w *walker) openAcquired(path string) (fs.File, error) {
+	var file fs.File
+	var err error
+	backoff := openRetryBackoff
+	for attempt := 0; ; attempt++ {
+		file, err = w.openRetrying(path)
+		if err == nil || !tooManyFiles(err) || attempt == openRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		w.releaseFiles(1)
+		return nil, err
+	}
+	if w.files == nil {
+		return file, nil
+	}
+	return &budgetedFile{File: file, release: func() { w.releaseFiles(1) }}, nil
+}
+
+// tooManyFiles reports whether err came of the process or the system running out of file
+// descriptors.
+func tooManyFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// budgetedFile is a file opened under MaxOpenFiles, which gives its room back when closed.
+type budgetedFile struct {
+	fs.File
+	release func()
+	once    sync.Once
+}
+
+func (f *budgetedFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.release)
+	return err
+}
+
+// ReadDir lists the file, if it is a directory which can be listed.
+func (f *budgetedFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, errors.New("not a directory")
+	}
+	return dir.ReadDir(n)
+}
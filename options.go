@@ -0,0 +1,433 @@
+package dirhash
+
+import (
+	"hash"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Options tunes the behavior of HashDirWithOptions. The zero value hashes exactly like HashDir.
+type Options struct {
+	// SkipSystemDirs leaves out the bookkeeping directories which operating systems create at
+	// the top of whole volumes, and which usually can't be read without special privileges. Any
+	// directory, at any depth, with one of these exact names is skipped entirely:
+	//
+	//	lost+found
+	//	.Trashes
+	//	$RECYCLE.BIN
+	//	System Volume Information
+	//
+	// This is off by default, so that nothing is silently left out of a hash.
+	SkipSystemDirs bool
+
+	// Exclude lists glob patterns, in the syntax of path.Match, for entries to be left out of
+	// the hash entirely. A pattern without a slash, such as "*.o" or "node_modules", matches any
+	// file or directory with that name at any depth. A pattern with a slash, such as "docs/*.md",
+	// must match the entry's whole slash-separated path relative to the root. Everything beneath
+	// an excluded directory is excluded with it. Excluded entries are simply omitted from the
+	// pseudo-files, so the hash is the same as if they had never existed.
+	Exclude []string
+
+	// Include, if it isn't empty, limits the hash to the files which match at least one of its
+	// patterns, written as for Exclude. Directories are always searched whatever their names,
+	// and a directory left with no matching files is hashed as an empty directory. Exclude takes
+	// precedence over Include.
+	Include []string
+
+	// IgnoreFiles names files, such as ".dirhashignore" or ".gitignore", which are read from
+	// every directory in the tree and list entries to be left out of the hash, in exactly the
+	// syntax of gitignore: comments, negation with '!', patterns ending in '/' which only match
+	// directories, patterns containing a '/' which are anchored to the directory of the ignore
+	// file, and "**" for any number of directories. Rules from a file apply to everything
+	// beneath its directory, and rules from deeper files take precedence. Ignore files above
+	// the root aren't consulted. The ignore files themselves are hashed like any other file,
+	// unless they are ignored too.
+	IgnoreFiles []string
+
+	// Symlinks determines how symbolic links are treated, both inside the tree and when the
+	// directory being hashed is itself a link.
+	Symlinks SymlinkMode
+
+	// Algorithm is the hash function applied to both files and pseudo-files. The format of the
+	// pseudo-files is the same whatever the algorithm, only the digests embedded in them differ.
+	// ShellSortCompat always uses SHA256.
+	Algorithm Algorithm
+
+	// NewHash, if set, overrides Algorithm with any hash function at all, such as sha512.New, the
+	// New method of a crypto.Hash, or a closure returning an HMAC keyed with a secret. It is
+	// called afresh for every file and directory. Manifests written with a custom hash name
+	// their algorithm as "custom", and can't be verified by this package.
+	NewHash func() hash.Hash
+
+	// PerFileTimeout bounds the time spent opening, reading, and hashing any single file, so
+	// that one file on a hung network mount, or a named pipe which nothing ever writes to, can't
+	// stall the whole hash forever. A file which takes longer fails with an *os.PathError
+	// wrapping ErrFileTimeout, which aborts the hash or is skipped according to OnError, like
+	// any other file which can't be read. The time includes any retries under ReadRetries.
+	// Stalled reads can't actually be interrupted, so the goroutine stuck on one is left behind
+	// until the read finally returns, still holding its place under MaxOpenFiles. Zero means no
+	// limit.
+	PerFileTimeout time.Duration
+
+	// ReadRetries is how many times opening or reading a file is retried when it fails with an
+	// error which may well be transient, such as a stale file handle, an I/O error, or a timeout
+	// on a network filesystem, before giving up on it. A file whose read fails is reopened and
+	// read on from where it left off, so long as its size and modification time are unchanged.
+	// Retrying doesn't change the hash, only whether one is produced. Zero means no retries.
+	ReadRetries int
+
+	// ReadRetryDelay is how long to wait before the first retry under ReadRetries, with the wait
+	// doubling before each one after that, up to a minute. Zero means 100ms.
+	ReadRetryDelay time.Duration
+
+	// OnError says what to do about files and directories which can't be read, whether to stop
+	// or to skip past them. ShellSortCompat always stops.
+	OnError ErrorPolicy
+
+	// OnLocked says what to do about files which can't be read because another process has
+	// locked them, failing with an *os.PathError wrapping ErrFileLocked. By default they go by
+	// OnError like anything else which can't be read, but they can instead always be skipped,
+	// or always abandon the hash. Locks are often only held for a moment, so locked files are
+	// retried under ReadRetries. Files are only ever locked on Windows, where they are opened
+	// sharing as much as possible with other processes, and with backup semantics, so that a
+	// file is only reported as locked when it truly can't be read.
+	OnLocked LockedPolicy
+
+	// OnSkip, if set, is called with the path of each entry skipped under ErrorSkip or
+	// ErrorCollect, along with the error which made it unreadable.
+	OnSkip func(path string, err error)
+
+	// NestingLimit bounds how many directories deep the tree may go beneath the root. A deeper
+	// directory fails with an *os.PathError wrapping ErrTooDeep, which aborts the hash. The walk
+	// keeps a stack of its own, so any depth can be hashed given the memory for it; this is for
+	// refusing a pathological or malicious tree with a clear error instead. Zero means no limit.
+	NestingLimit int
+
+	// MaxDepth stops the hash that many directories below the root, as 'find -maxdepth' does:
+	// with a MaxDepth of 1, only the entries of the root itself are hashed. A directory at the
+	// limit is listed by its name and any metadata, with "+" in place of a hash to say that it
+	// is there, but nothing in it is read. This gives a quick fingerprint of the upper levels
+	// of a very deep tree. Directories beyond the limit are never reported to any callback, nor
+	// included by TreeWithOptions and Walk. Under ShellSortCompat it leaves out the files below
+	// the limit, just as 'find -maxdepth' would. It changes the hash of any tree deeper than the
+	// limit. Zero means no limit.
+	MaxDepth int
+
+	// SpecialFiles says what to do about named pipes, sockets, and device nodes: read them like
+	// any other file, as by default, skip them, list them with a marker saying what they are,
+	// or fail. ShellSortCompat lists only regular files, so there they are left out unless
+	// refused. Special files listed by SpecialRecord aren't reported to OnFile or its like, and
+	// can't be packed or copied. SpecialSkip and SpecialRecord change the hash of any tree with
+	// special files in it.
+	SpecialFiles SpecialFileMode
+
+	// OneFileSystem stops the hash at mount points, as 'find -xdev' does, so that hashing / or
+	// the root of a container doesn't wander into /proc, /sys, or network mounts. A directory
+	// on a different device from the root is listed as at MaxDepth, with "+" in place of a
+	// hash, and nothing in it is read. Under ShellSortCompat the files beneath it are left out.
+	// Where there are no device numbers, such as on Windows or within an fs.FS, it has no
+	// effect. It changes the hash of any tree spanning several filesystems.
+	OneFileSystem bool
+
+	// SlowFileThreshold is how long a single file may take to be read and hashed before it is
+	// reported to OnSlowFile. Zero disables the check.
+	SlowFileThreshold time.Duration
+
+	// DirConcurrency is the number of directories which may be enumerated and hashed at once.
+	// Values of zero and one hash the tree one directory at a time, which is cheap and suits
+	// local disks; on network filesystems, where the latency of listing each directory rather
+	// than CPU time dominates, values up to 16 or so can help considerably. Each goroutine holds
+	// at most one directory open at a time, so DirConcurrency also bounds the open directory
+	// handles. Files within a directory are hashed by whichever goroutine listed it, unless
+	// FileConcurrency allows more.
+	DirConcurrency int
+
+	// FileConcurrency is the number of files which may be read and hashed at once, across the
+	// whole tree. Values of zero and one hash files one at a time. On SSDs and machines with
+	// many cores, setting it to around the number of CPUs lets the hashing of large files
+	// proceed in parallel rather than leaving most of the available throughput unused. The
+	// pseudo-files are assembled in order of name as always, so it has no effect on the hash.
+	// Each goroutine holds at most one file open at a time.
+	FileConcurrency int
+
+	// MaxOpenFiles bounds the files and directories held open at once, across the whole tree,
+	// for programs which need to keep most of their file descriptors for other things. Anything
+	// beyond the limit waits until something else is closed. A value of one is treated as two,
+	// since comparing files under DedupContent holds two open at once. Zero means no limit
+	// besides the concurrency options. Whatever the limit, opening a file when the process or
+	// the system has run out of file descriptors is retried a few times over a couple of
+	// seconds, in case some are given back, before giving up.
+	MaxOpenFiles int
+
+	// MmapThreshold, if positive, memory-maps every regular file at least MmapThreshold bytes
+	// long and hashes it straight from the mapping, rather than copying it through a buffer with
+	// read calls. This can be faster for large files on a local SSD, and matters less elsewhere.
+	// Files are only mapped on Unix systems, and not while ReadRetries is set, since a mapping
+	// can't be retried; any file which can't be mapped is simply read as usual. A file which is
+	// truncated while it is mapped fails to hash, rather than crashing the program. It never
+	// changes the hash.
+	MmapThreshold int64
+
+	// IOUring, which is experimental, reads small files through io_uring on Linux 5.6 and later,
+	// rather than with a read call or two each. Reads from every goroutine hashing files are
+	// gathered up and submitted together, so that a tree of countless tiny files, where the
+	// time goes into the calls rather than the reading, is read in far fewer calls and with the
+	// reads overlapping. It only helps alongside a high FileConcurrency. Files opened for
+	// ReadRetries, and files large enough for MmapThreshold, are read as usual, as is everything
+	// wherever io_uring isn't available. It never changes the hash.
+	IOUring bool
+
+	// DedupContent avoids hashing the same contents over and over in trees full of duplicate
+	// files. Each file is first given a cheap key, its size plus the SHA256 of its first 4KiB,
+	// and a bounded LRU cache maps recently seen keys to the full hash of a file with that key.
+	// When a key is found in the cache the two files are compared byte for byte, and the cached
+	// hash is reused only if they really are identical; files no larger than 4KiB are covered
+	// entirely by their key and need no comparison. A key which matches without the contents
+	// matching is simply hashed as normal, so the result is always exactly what it would have
+	// been without this option. It pays off when hashing is slower than reading the data again,
+	// as with SHA256 on machines without hardware support for it.
+	DedupContent bool
+
+	// Cache, if set, is consulted for the hash of each file before reading it, and remembers the
+	// hashes of the files which have to be read, so that hashing the same tree again only reads
+	// what has changed. See HashCache for when a cached hash is trusted. It never changes the
+	// hash of anything, except by trusting a file which was changed behind its back.
+	Cache *HashCache
+
+	// Checkpoint, if set, records the hash of every directory in a file as soon as it has been
+	// hashed, and takes the hash of any directory already recorded there from an earlier hash
+	// which was interrupted, rather than reading it again. See Checkpoint for what is trusted.
+	// Directories taken from it aren't reported to Progress or counted in Stats, and it can't be
+	// used where every file must be seen, as when writing a manifest or calling OnFile. It never
+	// changes the hash, except by trusting a directory which was changed in between.
+	// ShellSortCompat and ContentOnly ignore it.
+	Checkpoint *Checkpoint
+
+	// Transform, if set, is handed a reader for the contents of each file along with its path,
+	// and returns the reader whose output is hashed in place of the file's real contents. This
+	// allows fingerprints of normalized contents, such as decompressing files or stripping out
+	// boilerplate, without first rewriting the tree. It changes the hash of every file it alters,
+	// and it is up to the caller to make sure the transformation is itself deterministic. If the
+	// returned reader is an io.Closer, it is closed once the file has been hashed. DedupContent
+	// is ignored while a Transform is in use.
+	Transform func(path string, r io.Reader) (io.Reader, error)
+
+	// ChunkSize, if positive, hashes every file larger than ChunkSize bytes a chunk at a time,
+	// each chunk hashed by itself, and gives the file the hash of a pseudo-file listing the
+	// chunk size and the hash of every chunk in turn:
+	//
+	//	chunksize=1073741824
+	//	<hash of the first chunk, in capitalized hexadecimal>
+	//	<hash of the second chunk>
+	//	...
+	//
+	// This makes each file a small Merkle tree of its own, and manifests then record the hash
+	// of every chunk, so that VerifyChunks can check a huge file piecemeal and resume wherever
+	// it left off. Files no larger than a single chunk are hashed whole, just as without it.
+	// Under DomainSeparateNodes each chunk is tagged as a file and the list as a directory.
+	// It changes the hash of every larger file, and ShellSortCompat ignores it.
+	ChunkSize int64
+
+	// VerifyCopy makes HashAndCopyWithOptions hash the finished copy over again, to confirm
+	// that it really is faithful to the original. It has no effect elsewhere.
+	VerifyCopy bool
+
+	// SelfHashedManifest makes WriteManifestWithOptions end the manifest with a footer line
+	// holding a hash of everything before it, along with the root hash of the directory. This
+	// lets VerifyManifestIntegrity detect a manifest which was corrupted or truncated while in
+	// storage, before it is trusted to verify anything. It has no effect on HashDirWithOptions.
+	SelfHashedManifest bool
+
+	// ZeroTerminated makes WriteManifestWithOptions end every line of the manifest with a NUL
+	// byte rather than a newline, and write names as they are rather than escaping them, as
+	// 'sha256sum --zero' does. Names containing newlines then survive unchanged through tools
+	// such as 'xargs -0'. Manifests are read either way, so it isn't needed to read them back.
+	ZeroTerminated bool
+
+	// Logger, if set, is told about the progress of the hash: each directory as it is hashed at
+	// the info level, and each file at the debug level, along with the pseudo-file of every
+	// directory. Entries skipped under OnError are logged as warnings. Nothing is logged
+	// without one, and it never changes the hash.
+	Logger *slog.Logger
+
+	// OnSlowFile is called with the path of each file which took longer than SlowFileThreshold,
+	// along with the time it took. Slow files are usually a sign of a stalled network mount or
+	// an unexpectedly huge file.
+	OnSlowFile func(path string, d time.Duration)
+
+	// ProgressWithETA is called after each file is hashed with the number of bytes hashed so
+	// far, the total number expected, and an estimate of the time remaining based on a moving
+	// average of the throughput. The total comes from calling Estimate before hashing begins,
+	// so if files grow in the meantime the count is held at 99% of the total until the hash
+	// completes. A final call with done equal to total, and an ETA of zero, marks completion.
+	// It may be called from several goroutines, though never from more than one at a time.
+	ProgressWithETA func(done, total int64, eta time.Duration)
+
+	// Progress is called as the hash goes along: whenever a directory has been listed, and
+	// before and after each file is hashed. Each Event carries running totals of the files found
+	// and hashed so far, so a progress display can be drawn without knowing in advance how big
+	// the tree is, unlike with ProgressWithETA. It is called as things happen, whatever
+	// StableOutput says, and may be called from several goroutines, though never from more than
+	// one at a time. It should return quickly, since the hash waits for it.
+	Progress func(e Event)
+
+	// RelativeErrors makes every path reported back to the caller relative to the directory
+	// being hashed, rather than beginning with it: the paths given to callbacks, and the paths
+	// inside any *os.PathError returned. This keeps output short and portable, and avoids
+	// revealing where on the machine the tree lives. It has no effect on the hash.
+	RelativeErrors bool
+
+	// OnFile is called with every file once it has been hashed.
+	//
+	// When DirConcurrency or FileConcurrency is greater than one, OnFile and OnSlowFile may be
+	// called from several goroutines at once and in no particular order, unless StableOutput is
+	// set.
+	OnFile func(e Entry)
+
+	// StableOutput guarantees that callbacks are made one at a time, and in exactly the order a
+	// single goroutine would make them: depth-first through the tree, with the entries of each
+	// directory in order of name. Since manifests are written from these callbacks, this makes
+	// the text of a manifest deterministic too. The hash itself is deterministic regardless.
+	// The cost is that every callback is buffered in memory until the whole tree has been
+	// hashed, and only then delivered, which takes memory in proportion to the number of files.
+	StableOutput bool
+
+	// ShellSortCompat replaces the usual recursive algorithm with a flat listing which can be
+	// reproduced using standard shell tools. The digest is the SHA256 of the output of
+	//
+	//     find . -type f -print0 | LC_ALL=C sort -z | xargs -0 sha256sum
+	//
+	// run from within the directory being hashed. Only regular files are included, ordered by
+	// the bytes of their full "./"-prefixed path, so empty directories and symbolic links do
+	// not contribute to the hash.
+	ShellSortCompat bool
+
+	// StructureOnly hashes only the layout of the tree and the names in it, without reading any
+	// file at all. Every file's line in a pseudo-file gives a fixed placeholder in place of its
+	// hash:
+	//
+	//	0CE63AFC1E92EE82744300A778E523B9F42A53FE99201BD39FB8E2DE82965297 "empty"
+	//	=
+	//	- "asd.txt"
+	//
+	// Only directory listings are consulted, so this is fast on even the largest trees, and any
+	// file or directory added, removed, or renamed changes the result. Edits to files don't.
+	// Like ShapeHash, but without the sizes. Other attributes such as Metadata are still
+	// recorded. ShellSortCompat ignores it.
+	StructureOnly bool
+
+	// ContentOnly hashes only what the files in the tree contain, ignoring their names and
+	// where they are, so that a tree which has merely been reorganized keeps the same hash. The
+	// digest is the hash of the sorted lines
+	//
+	//	<file hash in capitalized hexadecimal>
+	//
+	// one for every file, duplicates included, making it a fingerprint of the multiset of file
+	// contents. Empty directories make no difference. It can't be combined with StructureOnly,
+	// and ShellSortCompat ignores it.
+	ContentOnly bool
+
+	// CollapseChains makes directories which contain exactly one subdirectory and nothing else
+	// transparent: such a directory hashes to exactly the same value as its lone subdirectory,
+	// instead of a pseudo-file listing it. The collapse is applied bottom-up, so an entire chain
+	// like "a/b/c/d" hashes as though d's contents were directly inside "a", and the line listing
+	// "a" in its parent keeps the name "a". Since only the outermost name of a chain is ever
+	// recorded, collapsing can never cause two entries to merge. The root directory collapses
+	// like any other. This changes the resulting hash whenever such a chain is present.
+	CollapseChains bool
+
+	// IncludeRootMtime records the modification time of the root directory itself, so that
+	// touching the root changes the hash. Nothing else's metadata is affected. The time is
+	// written as a line of its own at the very start of the root's pseudo-file, before any
+	// entries, giving whole seconds and nanoseconds since the Unix epoch:
+	//
+	//	mtime=1700000000.123456789
+	//
+	// With this set the root is never collapsed by CollapseChains. It changes the hash, and so
+	// is off by default.
+	IncludeRootMtime bool
+
+	// Metadata records the chosen metadata of every file and subdirectory in its pseudo-file
+	// line, after the name, so that chmod, chown, or touch changes the hash even when no
+	// contents do. For example, with all of it recorded:
+	//
+	//	<hash> "run.sh" mode=0755 uid=1000 gid=1000 mtime=1700000000.123456789
+	//
+	// Every pseudo-file then begins with a line giving the format version and what is recorded,
+	//
+	//	version=2 metadata=mode,owner,mtime
+	//
+	// so hashes with metadata can never be confused with those without, or with different
+	// metadata. The root's own metadata isn't recorded, having no line of its own, and
+	// CollapseChains has no effect. Symbolic links hashed with SymlinkHashTarget have no metadata
+	// recorded. It changes the hash, and is off by default.
+	Metadata Metadata
+
+	// Stats, if set, is filled in with statistics about the hash: how many files and directories
+	// it covered, how many bytes it read, how many entries it skipped, and how long it took. It
+	// is reset at the start of the hash and complete once the hash returns, whether or not it
+	// succeeds.
+	Stats *Stats
+
+	// DomainSeparateNodes prefixes the data fed into every hash with a single byte saying what
+	// kind of node it describes: 0x00 before the contents of a file, and 0x01 before the
+	// pseudo-file of a directory. This is the same leaf/node tagging used by the Merkle trees of
+	// Certificate Transparency (RFC 6962), and it guarantees that a file can never be passed off
+	// as a directory or vice versa, closing off second-preimage attacks which exploit that
+	// ambiguity. It changes every hash, and is the more secure choice where both sides agree to
+	// use it. ShellSortCompat ignores it.
+	DomainSeparateNodes bool
+
+	// IncludeCapabilities records the Linux file capabilities (the "security.capability"
+	// extended attribute) of each file which has any, so that granting a binary extra privileges
+	// with setcap changes the hash even though its contents don't change. The raw attribute value
+	// is appended to the file's pseudo-file line after its name, as in
+	//
+	//     <hash> "ping" capability=<value in capitalized hexadecimal>
+	//
+	// Files without capabilities are listed exactly as usual. Capabilities only exist on Linux,
+	// so elsewhere this option has no effect and hashes come out as though no file had any.
+	IncludeCapabilities bool
+
+	// IncludeXattrs records every extended attribute of each file and subdirectory, sorted by
+	// name, so that security labels and the like can't be changed without changing the hash.
+	// Each is appended to the entry's pseudo-file line after its name, as in
+	//
+	//     <hash> "ping" xattr="security.capability":<value in capitalized hexadecimal>
+	//
+	// with quotes and backslashes in the names escaped as in file names. Capabilities are then
+	// recorded as extended attributes like any other, and IncludeCapabilities has no further
+	// effect. Extended attributes are only read on Linux, so elsewhere this option has no effect.
+	IncludeXattrs bool
+
+	// IncludeStreams records the alternate data streams of each file and subdirectory on NTFS,
+	// sorted by name, with the hash of the contents of each, since a stream is otherwise an
+	// invisible place to hide anything alongside an innocent file. Each is appended to the
+	// entry's pseudo-file line after its name and any extended attributes, as in
+	//
+	//     <hash> "setup.exe" stream="Zone.Identifier":<hash of the stream's contents>
+	//
+	// with quotes and backslashes in the names escaped as in file names. Streams are hashed in
+	// the same way as the contents of files. They are only read on Windows, so elsewhere this
+	// option has no effect.
+	IncludeStreams bool
+
+	// AppleDouble says what to do about the resource forks and Finder information of files from
+	// macOS, and the AppleDouble files beginning with "._" which hold them wherever macOS copies
+	// files to a filesystem which can't, so that a tree can hash the same wherever it has been
+	// copied. By default neither is treated specially.
+	AppleDouble AppleDoubleMode
+}
+
+// Entry describes a single file which was hashed as part of a directory.
+type Entry struct {
+	Path string // The path the file was read from, beginning with the path being hashed unless RelativeErrors is set
+	Size int64  // The size of the file in bytes
+	Sum  []byte // The hash of the file contents
+
+	// Chunks holds the hash of each chunk of a file hashed in several chunks under ChunkSize,
+	// in order. It is nil for smaller files, and for any whose hash was found without reading
+	// them, such as from the Cache or another hard link to the same file.
+	Chunks [][]byte
+}
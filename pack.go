@@ -0,0 +1,104 @@
+package dirhash
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Pack hashes the directory at path while writing it to out as a tar archive, reading each
+// file only once, and returns the hash of the directory. The archive is reproducible: the
+// same tree always gives exactly the same bytes, whenever and wherever it is packed.
+func Pack(path string, out io.Writer) ([]byte, error) {
+	return PackWithOptions(path, out, Options{})
+}
+
+// PackWithOptions is like Pack, hashing the directory according to opts, and archiving exactly
+// the entries which the hash covers. Entries are written depth-first in order of name, the
+// order in which the hash visits them, with every directory before its contents. Their
+// metadata is normalized: each is owned by user and group 0, carries the Unix epoch as its
+// modification time, and has the permissions 0755 if it is a directory or executable and
+// otherwise 0644. Links to files are archived as the files they point to, except under
+// SymlinkHashTarget, when they are archived as links. Unpacking the archive and hashing the
+// result with the same options, or passing the archive to HashTar if no options were given,
+// reproduces the hash.
+//
+// Since entries must be written one at a time, the concurrency options have no effect, nor do
+// Cache, DedupContent, and PerFileTimeout. StructureOnly, ShellSortCompat, and SpecialRecord
+// can't be used.
+func PackWithOptions(path string, out io.Writer, opts Options) ([]byte, error) {
+	if opts.StructureOnly || opts.ShellSortCompat {
+		return nil, errors.New("cannot pack in StructureOnly or ShellSortCompat mode")
+	}
+	if opts.SpecialFiles == SpecialRecord {
+		return nil, errors.New("cannot pack special files as recorded by SpecialRecord")
+	}
+	opts.DirConcurrency, opts.FileConcurrency = 1, 1
+	opts.Cache, opts.DedupContent, opts.PerFileTimeout = nil, false, 0
+
+	w := newWalker(&opts)
+	w.packTo = tar.NewWriter(out)
+	hash, err := w.run(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.packTo.Close(); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// packName returns the name in the archive of whatever is at path.
+func (w *walker) packName(path string) string {
+	return strings.TrimPrefix(path, w.root+"/")
+}
+
+// packHeader returns a header for the entry at path with its metadata normalized.
+func (w *walker) packHeader(path string, kind byte, mode fs.FileMode) *tar.Header {
+	perm := int64(0644)
+	if mode.IsDir() || mode&0111 != 0 {
+		perm = 0755
+	}
+	return &tar.Header{
+		Typeflag: kind,
+		Name:     w.packName(path),
+		Mode:     perm,
+		ModTime:  time.Unix(0, 0),
+		Format:   tar.FormatPAX,
+	}
+}
+
+// packDir adds the directory at path to the archive. The root has no entry of its own.
+func (w *walker) packDir(path string) error {
+	if path == w.root {
+		return nil
+	}
+	header := w.packHeader(path, tar.TypeDir, fs.ModeDir)
+	header.Name += "/"
+	return w.packTo.WriteHeader(header)
+}
+
+// packFile adds the file at path to the archive, returning the writer its contents are to be
+// written to.
+func (w *walker) packFile(path string, file fs.File) (io.Writer, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := w.packHeader(path, tar.TypeReg, info.Mode())
+	header.Size = info.Size()
+	if err := w.packTo.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return w.packTo, nil
+}
+
+// packLink adds the symbolic link at path, pointing to target, to the archive.
+func (w *walker) packLink(path, target string) error {
+	header := w.packHeader(path, tar.TypeSymlink, 0777)
+	header.Linkname = target
+	return w.packTo.WriteHeader(header)
+}
@@ -0,0 +1,46 @@
+package dirhash
+
+// Patch lists the ways in which a directory differs from a reference manifest, with the new
+// hash of every file which was changed or added. A client holding the reference tree needs
+// only to fetch those files and delete the removed ones to bring its copy up to date.
+type Patch struct {
+	Algorithm Algorithm    // The algorithm of the reference manifest, used for every hash here
+	Sum       []byte       // The hash of the whole directory as it is now
+	Changed   []PatchEntry // Files whose contents differ from the reference
+	Added     []PatchEntry // Files which aren't in the reference at all
+	Removed   []string     // Files in the reference which no longer exist
+}
+
+// PatchEntry is a single changed or added file in a Patch.
+type PatchEntry struct {
+	Path string // The path of the file, relative to the root
+	Sum  []byte // The file's current hash
+}
+
+// HashPatch hashes the directory at path and compares every file in it against the reference
+// manifest ref, returning a Patch describing the differences. Paths in each list are sorted.
+func HashPatch(path string, ref Manifest) (*Patch, error) {
+	return HashPatchWithOptions(path, ref, Options{})
+}
+
+// HashPatchWithOptions is like HashPatch, but hashes the directory according to opts, which
+// should select the same files as when the manifest was written. The algorithm and chunk size
+// are always taken from the manifest, as for VerifyManifestWithOptions.
+func HashPatchWithOptions(path string, ref Manifest, opts Options) (*Patch, error) {
+	opts.Algorithm, opts.NewHash, opts.ChunkSize = ref.Algorithm, nil, ref.ChunkSize
+	root, files, err := collectFiles(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := &Patch{Algorithm: ref.Algorithm, Sum: root}
+	changed, added, removed := diffFiles(ref.Files, files)
+	for _, p := range changed {
+		patch.Changed = append(patch.Changed, PatchEntry{p, files[p]})
+	}
+	for _, p := range added {
+		patch.Added = append(patch.Added, PatchEntry{p, files[p]})
+	}
+	patch.Removed = removed
+	return patch, nil
+}
@@ -0,0 +1,69 @@
+package dirhash
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHashPatch(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"big":       strings.Repeat("x", 3000),
+		"same.txt":  "unchanged",
+		"edit.txt":  "before",
+		"gone.txt":  "removed",
+		"sub/c.txt": "gamma",
+	})
+
+	// Large files in a chunked manifest have hashes of their own, which the patch must match
+	var manifest bytes.Buffer
+	if err := WriteManifestWithOptions(root, &manifest, Options{Algorithm: AlgorithmSHA512, ChunkSize: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ReadManifest(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.ChunkSize != 1024 {
+		t.Fatalf("manifest chunk size is %d, want 1024", ref.ChunkSize)
+	}
+
+	if err := writeFile(root, "edit.txt", "after"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(root, "new.txt", "added"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rename(root, "gone.txt", "sub/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := HashPatch(root, *ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := func(entries []PatchEntry) string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Path)
+		}
+		return strings.Join(names, " ")
+	}
+	if got := paths(patch.Changed); got != "edit.txt" {
+		t.Errorf("changed files are %q, want %q", got, "edit.txt")
+	}
+	if got := paths(patch.Added); got != "new.txt sub/moved.txt" {
+		t.Errorf("added files are %q, want %q", got, "new.txt sub/moved.txt")
+	}
+	if got := strings.Join(patch.Removed, " "); got != "gone.txt" {
+		t.Errorf("removed files are %q, want %q", got, "gone.txt")
+	}
+
+	want, err := HashDirWithOptions(root, Options{Algorithm: AlgorithmSHA512, ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.Algorithm != AlgorithmSHA512 || !bytes.Equal(patch.Sum, want) {
+		t.Errorf("patch has %v hash %X, want sha512 hash %X", patch.Algorithm, patch.Sum, want)
+	}
+}
@@ -0,0 +1,178 @@
+package dirhash
+
+import (
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Estimate walks the tree at path without reading any files, and returns how many files and
+// bytes hashing it with opts would involve. It is cheap next to hashing, making it useful for
+// sizing up a job before starting on it.
+func Estimate(path string, opts Options) (files, bytes int64, err error) {
+	w := newWalker(&opts)
+	if err := w.findRootDevice(path); err != nil {
+		return 0, 0, err
+	}
+	err = w.estimate(path, &files, &bytes)
+	return files, bytes, err
+}
+
+func (w *walker) estimate(path string, files, bytes *int64) error {
+	// Only totals are wanted, so the directories can be visited in any order, and are kept on a
+	// stack rather than recursed into
+	stack := []walkDir{{path, 0}}
+	for len(stack) > 0 {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if err := w.checkNesting(dir.path, dir.depth); err != nil {
+			return err
+		}
+		contents, err := w.listDir(dir.path)
+		if err != nil {
+			return err
+		}
+		for _, x := range contents {
+			if x.IsDir() {
+				descend, err := w.descends(x, dir.depth+1)
+				if err != nil {
+					return err
+				}
+				if descend {
+					stack = append(stack, walkDir{w.join(dir.path, x.Name()), dir.depth + 1})
+				}
+				continue
+			}
+			info, err := x.Info()
+			if err != nil {
+				return err
+			}
+			*files++
+			*bytes += info.Size()
+		}
+	}
+	return nil
+}
+
+// walkDir is a directory waiting to be visited, depth directories below the root.
+type walkDir struct {
+	path  string
+	depth int
+}
+
+// etaSmoothing is the weight given to each new throughput measurement in the moving average
+// behind ProgressWithETA, the rest going to the measurements before it.
+const etaSmoothing = 0.1
+
+// etaTracker turns the bytes hashed so far into calls to ProgressWithETA.
+type etaTracker struct {
+	mu       sync.Mutex
+	callback func(done, total int64, eta time.Duration)
+	total    int64
+	done     int64
+	last     time.Time
+	rate     float64 // Smoothed bytes per second, or zero before the first measurement
+}
+
+func newETATracker(total int64, callback func(done, total int64, eta time.Duration)) *etaTracker {
+	return &etaTracker{callback: callback, total: total, last: time.Now()}
+}
+
+// add records n more bytes as hashed and reports the progress.
+func (t *etaTracker) add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(t.last).Seconds(); elapsed > 0 {
+		sample := float64(n) / elapsed
+		if t.rate == 0 {
+			t.rate = sample
+		} else {
+			t.rate = etaSmoothing*sample + (1-etaSmoothing)*t.rate
+		}
+		t.last = now
+	}
+	t.done += n
+
+	// If the tree has grown since it was estimated, hold at 99% rather than overshoot
+	done := t.done
+	if limit := t.total * 99 / 100; done > limit {
+		done = limit
+	}
+	var eta time.Duration
+	if t.rate > 0 {
+		eta = time.Duration(float64(t.total-done) / t.rate * float64(time.Second))
+	}
+	t.callback(done, t.total, eta)
+}
+
+// finish reports the hash as complete, with however many bytes were actually hashed.
+func (t *etaTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.callback(t.done, t.done, 0)
+}
+
+// EventKind says what prompted an Event.
+type EventKind int
+
+const (
+	// EventDirListed reports that a directory has been listed, and the files directly inside
+	// it added to FilesFound. Path is the directory.
+	EventDirListed EventKind = iota
+
+	// EventFileStarted reports that a file is about to be read. Path is the file.
+	EventFileStarted
+
+	// EventFileHashed reports that a file has been hashed, and added to FilesHashed and
+	// BytesHashed. Path is the file.
+	EventFileHashed
+)
+
+// Event reports the progress of a hash to Options.Progress. The counts are running totals
+// for the whole hash so far.
+type Event struct {
+	Kind        EventKind
+	Path        string // The file or directory concerned, displayed as for an Entry
+	FilesFound  int64  // Files seen in the directories listed so far
+	FilesHashed int64  // Files which have been completely hashed
+	BytesHashed int64  // The total size of those files
+}
+
+// progressTracker keeps the running totals behind Options.Progress.
+type progressTracker struct {
+	mu       sync.Mutex
+	callback func(Event)
+	counts   Event
+}
+
+// report updates the totals by the given amounts and passes on an event of the given kind.
+func (t *progressTracker) report(kind EventKind, path string, found, hashed, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts.FilesFound += found
+	t.counts.FilesHashed += hashed
+	t.counts.BytesHashed += bytes
+
+	event := t.counts
+	event.Kind, event.Path = kind, path
+	t.callback(event)
+}
+
+// reportListed reports that the directory at path has been listed with the given contents.
+func (w *walker) reportListed(path string, contents []fs.DirEntry) {
+	if w.progress == nil {
+		return
+	}
+	var files int64
+	for _, x := range contents {
+		if !x.IsDir() {
+			files++
+		}
+	}
+	w.progress.report(EventDirListed, w.display(path), files, 0, 0)
+}
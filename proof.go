@@ -0,0 +1,260 @@
+package dirhash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Proof shows that a file with a particular hash is part of the hash of a directory, without
+// the rest of the tree. Alongside the file's hash it holds the pseudo-file of each directory on
+// the way from the file up to the root: each lists the hash of the entry below it, and hashes
+// to the hash listed in the one above it, up to the root hash itself. Anyone holding the root
+// hash, such as one published with a release, can then check a single file downloaded from the
+// tree against it, without fetching a manifest of the whole tree. A proof is only as large as
+// the listings of the directories it passes through.
+type Proof struct {
+	Path      string    // The path of the file relative to the root, with slashes between names
+	Hash      []byte    // The hash of the file, as listed in its directory
+	Algorithm Algorithm // The algorithm the tree was hashed with
+	ChunkSize int64     // The Options.ChunkSize the tree was hashed with, which the file's hash depends on
+
+	// DomainSeparateNodes records whether the tree was hashed with Options.DomainSeparateNodes.
+	DomainSeparateNodes bool
+
+	// Dirs holds the pseudo-file of each directory holding the file, beginning with the one
+	// which lists the file itself and ending with the root.
+	Dirs [][]byte
+}
+
+// ErrNotInHash is the underlying error when Prove is asked for a file which the hash doesn't
+// cover, such as one which was excluded, skipped, or beyond MaxDepth.
+var ErrNotInHash = errors.New("file not covered by the hash")
+
+// ErrProofMismatch is returned when a proof doesn't hold: either its pseudo-files don't chain
+// up to the root hash, or the file given doesn't have the hash the proof is for.
+var ErrProofMismatch = errors.New("proof does not match")
+
+// Prove hashes the directory at root according to opts, and returns a proof that the file at
+// the path file, relative to root, is included in its hash. The file may also be a symbolic link
+// hashed by its target under SymlinkHashTarget. ShellSortCompat, ContentOnly, CollapseChains,
+// StructureOnly, and NewHash can't be used, since they give no file a hash which can be proved
+// and checked by itself.
+func Prove(root, file string, opts Options) (*Proof, error) {
+	switch {
+	case opts.ShellSortCompat || opts.ContentOnly:
+		return nil, errors.New("cannot prove a file in ShellSortCompat or ContentOnly mode")
+	case opts.CollapseChains:
+		return nil, errors.New("cannot prove a file with CollapseChains, which leaves the names of some directories out of the hash")
+	case opts.StructureOnly:
+		return nil, errors.New("cannot prove a file with StructureOnly, which gives no file a hash")
+	case opts.NewHash != nil:
+		return nil, errors.New("cannot prove a file with a custom NewHash, which a proof can't name")
+	}
+	rel := filepath.ToSlash(filepath.Clean(file))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return nil, fmt.Errorf("%s: not a path within the directory", file)
+	}
+	names := strings.Split(rel, "/")
+
+	// Keep the pseudo-file of every directory on the way down to the file
+	w := newWalker(&opts)
+	w.proofDirs = make(map[string]*strings.Builder)
+	dir := root
+	for _, name := range names {
+		w.proofDirs[dir] = new(strings.Builder)
+		dir = w.join(dir, name)
+	}
+	hash, err := w.run(root)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &Proof{Path: rel, Algorithm: opts.Algorithm, ChunkSize: opts.ChunkSize, DomainSeparateNodes: opts.DomainSeparateNodes}
+	dir = root
+	for _, name := range names {
+		// A directory which was never hashed has no pseudo-file at all, not even an empty one
+		pseudoFile := w.proofDirs[dir].String()
+		if pseudoFile == "" {
+			return nil, &os.PathError{Op: "prove", Path: file, Err: ErrNotInHash}
+		}
+		proof.Dirs = append([][]byte{[]byte(pseudoFile)}, proof.Dirs...)
+		dir = w.join(dir, name)
+	}
+	listed, ok := findProofEntry(proof.Dirs[0], names[len(names)-1], false)
+	if !ok {
+		return nil, &os.PathError{Op: "prove", Path: file, Err: ErrNotInHash}
+	}
+	if proof.Hash, err = hex.DecodeString(listed); err != nil {
+		// Whatever stands in for the hash of a skipped or special file can't be proved
+		return nil, &os.PathError{Op: "prove", Path: file, Err: ErrNotInHash}
+	}
+	if err := proof.Verify(hash); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// Verify checks that the proof chains up from the file's hash to root, the hash of the whole
+// directory, returning ErrProofMismatch if it doesn't.
+func (p *Proof) Verify(root []byte) error {
+	names := strings.Split(p.Path, "/")
+	if len(p.Dirs) != len(names) {
+		return ErrProofMismatch
+	}
+	hash := p.Hash
+	for i, pseudoFile := range p.Dirs {
+		listed, ok := findProofEntry(pseudoFile, names[len(names)-1-i], i > 0)
+		if !ok || listed != fmt.Sprintf("%X", hash) {
+			return ErrProofMismatch
+		}
+		hasher := p.Algorithm.New()
+		if p.DomainSeparateNodes {
+			hasher.Write([]byte{nodePrefix})
+		}
+		hasher.Write(pseudoFile)
+		hash = hasher.Sum(nil)
+	}
+	if !bytes.Equal(hash, root) {
+		return ErrProofMismatch
+	}
+	return nil
+}
+
+// VerifyFile checks that the contents of the file at path have the hash the proof is for,
+// hashing it the same way the tree was, and that the proof chains up from there to root. The
+// file may be kept anywhere and under any name, since the proof says where it belongs.
+func (p *Proof) VerifyFile(path string, root []byte) error {
+	w := newWalker(&Options{Algorithm: p.Algorithm, ChunkSize: p.ChunkSize, DomainSeparateNodes: p.DomainSeparateNodes})
+	hash, err := w.hashContents(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, p.Hash) {
+		return &os.PathError{Op: "verify", Path: path, Err: ErrProofMismatch}
+	}
+	return p.Verify(root)
+}
+
+// proofVersion is the version of the JSON layout of a proof, which only changes when fields are
+// removed or change meaning.
+const proofVersion = 1
+
+// jsonProof is the JSON layout of a proof. The pseudo-files are given in base64, since the names
+// in them needn't be valid UTF-8.
+type jsonProof struct {
+	Version   int      `json:"version"`
+	Path      string   `json:"path"`
+	Algorithm string   `json:"algorithm"`
+	ChunkSize int64    `json:"chunksize,omitempty"`
+	Tagged    bool     `json:"tagged,omitempty"`
+	Hash      string   `json:"hash"`
+	Dirs      [][]byte `json:"dirs"`
+}
+
+// MarshalJSON encodes the proof as a single JSON object, with the file's hash in capitalized
+// hexadecimal and each pseudo-file in base64, so that it can be published alongside a file.
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonProof{
+		Version:   proofVersion,
+		Path:      p.Path,
+		Algorithm: p.Algorithm.String(),
+		ChunkSize: p.ChunkSize,
+		Tagged:    p.DomainSeparateNodes,
+		Hash:      fmt.Sprintf("%X", p.Hash),
+		Dirs:      p.Dirs,
+	})
+}
+
+// UnmarshalJSON decodes a proof encoded by MarshalJSON.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var in jsonProof
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in.Version != proofVersion {
+		return fmt.Errorf("unsupported proof version %d", in.Version)
+	}
+	algorithm, err := ParseAlgorithm(in.Algorithm)
+	if err != nil {
+		return err
+	}
+	hash, err := hex.DecodeString(in.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid hash in proof: %v", err)
+	}
+	*p = Proof{Path: in.Path, Hash: hash, Algorithm: algorithm, ChunkSize: in.ChunkSize, DomainSeparateNodes: in.Tagged, Dirs: in.Dirs}
+	return nil
+}
+
+// findProofEntry finds the line listing name in a pseudo-file, among the subdirectories if dir
+// is set and among the files otherwise, and returns whatever is written in place of its hash.
+func findProofEntry(pseudoFile []byte, name string, dir bool) (string, bool) {
+	inFiles := false
+	for _, line := range splitPseudoFile(pseudoFile) {
+		if line == "=" {
+			inFiles = true
+			continue
+		}
+		if inFiles == dir {
+			continue
+		}
+		// Lines which aren't entries, such as the metadata header, have no quoted name
+		if hash, entryName, ok := parseEntryLine(line); ok && entryName == name {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+// splitPseudoFile splits a pseudo-file into its lines. A newline only ends a line outside of
+// quotes, since names and attributes may contain newlines of their own.
+func splitPseudoFile(pseudoFile []byte) []string {
+	var lines []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range pseudoFile {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == '\n' && !quoted:
+			lines = append(lines, string(pseudoFile[start:i]))
+			start = i + 1
+		}
+	}
+	return lines
+}
+
+// parseEntryLine splits a line of a pseudo-file, as written by writeEntry, into whatever stands
+// in for the hash and the unescaped name.
+func parseEntryLine(line string) (hash, name string, ok bool) {
+	i := strings.Index(line, " \"")
+	if i < 0 || strings.Contains(line[:i], " ") {
+		return "", "", false
+	}
+	var out strings.Builder
+	for j := i + 2; j < len(line); j++ {
+		switch line[j] {
+		case '\\':
+			j++
+			if j < len(line) {
+				out.WriteByte(line[j])
+			}
+		case '"':
+			return line[:i], out.String(), true
+		default:
+			out.WriteByte(line[j])
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,149 @@
+package dirhash
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Under ReadRetries, the first retry waits ReadRetryDelay, or this long if it isn't set, and
+// each one after waits twice as long as the last, up to maxReadRetryDelay.
+const (
+	defaultReadRetryDelay = 100 * time.Millisecond
+	maxReadRetryDelay     = time.Minute
+)
+
+// transientErrors are the errors which may well go away if the same thing is tried again, such
+// as a network filesystem losing track of an open file, or timing out.
+var transientErrors = []syscall.Errno{
+	syscall.ESTALE,
+	syscall.EIO,
+	syscall.ETIMEDOUT,
+	syscall.EAGAIN,
+	syscall.ECONNRESET,
+}
+
+// transientError reports whether err is one worth retrying under ReadRetries. A file locked by
+// another process is, since the lock is often only held for a moment, as by a virus scanner.
+func transientError(err error) bool {
+	if isLocked(err) {
+		return true
+	}
+	for _, errno := range transientErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitToRetry waits before the given retry under ReadRetries, counting from zero, returning
+// early with an error if the hash is abandoned in the meantime.
+func (w *walker) waitToRetry(retry int) error {
+	delay := w.opts.ReadRetryDelay
+	if delay <= 0 {
+		delay = defaultReadRetryDelay
+	}
+	for i := 0; i < retry && delay < maxReadRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxReadRetryDelay {
+		delay = maxReadRetryDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// openRetrying opens the file at path, trying again under ReadRetries if it fails with a
+// transient error. A regular file is returned as a retryingFile, so that reading it is retried
+// in the same way.
+func (w *walker) openRetrying(path string) (fs.File, error) {
+	for retry := 0; ; retry++ {
+		file, err := w.openFile(path)
+		if err == nil {
+			return w.retrying(path, file), nil
+		}
+		if !transientError(err) || retry >= w.opts.ReadRetries || w.waitToRetry(retry) != nil {
+			return nil, err
+		}
+	}
+}
+
+// retrying returns file as a retryingFile, if ReadRetries is set and it is a regular file.
+func (w *walker) retrying(path string, file fs.File) fs.File {
+	if w.opts.ReadRetries <= 0 {
+		return file
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return file
+	}
+	return &retryingFile{File: file, w: w, path: path, info: info}
+}
+
+// retryingFile is a regular file opened under ReadRetries. When a read fails with a transient
+// error it is reopened, and reading carries on from where it left off, so long as the file
+// still looks the same as when it was first opened.
+type retryingFile struct {
+	fs.File
+	w      *walker
+	path   string
+	info   fs.FileInfo // The file as it was first opened
+	offset int64       // How much of it has been read so far
+}
+
+func (f *retryingFile) Read(p []byte) (int, error) {
+	for retry := 0; ; retry++ {
+		n, err := f.File.Read(p)
+		f.offset += int64(n)
+		if err == nil || !transientError(err) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil // The next read will fail and be retried in turn
+		}
+		if retry >= f.w.opts.ReadRetries || f.w.waitToRetry(retry) != nil {
+			return 0, err
+		}
+		if reopenErr := f.reopen(); reopenErr != nil && !transientError(reopenErr) {
+			return 0, err
+		}
+	}
+}
+
+// reopen replaces the file with a newly opened one, positioned where the last read left off.
+// It fails if the file has since changed size or been modified, since the rest of it might not
+// follow on from what has been read already.
+func (f *retryingFile) reopen() error {
+	file, err := f.w.openFile(f.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil && (info.Size() != f.info.Size() || !info.ModTime().Equal(f.info.ModTime())) {
+		err = &fs.PathError{Op: "reopen", Path: f.path, Err: errors.New("file changed while being read")}
+	}
+	if err == nil {
+		if seeker, ok := file.(io.Seeker); !ok {
+			err = &fs.PathError{Op: "reopen", Path: f.path, Err: errors.New("file can't be read from where it left off")}
+		} else {
+			_, err = seeker.Seek(f.offset, io.SeekStart)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.File.Close()
+	f.File = file
+	return nil
+}
@@ -0,0 +1,19 @@
+package dirhash
+
+// ShapeHash computes a fingerprint of the shape of the tree at path: its names, its layout, and
+// the size of every file, without reading the contents of any file at all. It follows the same
+// algorithm as HashDir, except that each file's line in a pseudo-file gives its size in decimal
+// in place of its hash:
+//
+//	0CE63AFC1E92EE82744300A778E523B9F42A53FE99201BD39FB8E2DE82965297 "empty"
+//	=
+//	1024 "asd.txt"
+//
+// Since only directory listings are consulted, this is fast even on enormous trees, and makes a
+// cheap pre-filter before a full hash: any file added, removed, renamed, or resized changes the
+// result. An edit which leaves a file's size unchanged does not, however.
+func ShapeHash(path string) ([]byte, error) {
+	w := newWalker(&Options{})
+	w.shape = true
+	return w.hashDir(path, 0, nil)
+}
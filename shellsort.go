@@ -0,0 +1,101 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// hashShellSorted computes the ShellSortCompat digest of the tree at root. The result is the
+// SHA256 of exactly the text printed by
+//
+//	cd root && find . -type f -print0 | LC_ALL=C sort -z | xargs -0 sha256sum
+//
+// which is to say one line per regular file, ordered by the literal bytes of its "./"-prefixed
+// path, in the format emitted by GNU sha256sum.
+func (w *walker) hashShellSorted(root string) ([]byte, error) {
+	// Collect the relative path of every regular file under the root
+	var files []shellFile
+	if err := w.listRegularFiles(root, &files); err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+
+	// Feed a sha256sum line for each file into the hash in sorted order
+	hasher := sha256.New()
+	for _, f := range files {
+		if err := w.ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash, err := w.hashFile(w.join(root, strings.TrimPrefix(f.rel, "./")), f.info, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(hasher, sumLine(hash, f.rel)); err != nil {
+			return nil, err
+		}
+	}
+	return hasher.Sum(nil), nil
+}
+
+type shellFile struct {
+	rel  string
+	info os.FileInfo
+}
+
+// listRegularFiles appends all regular files beneath root to files, mimicking `find -type f`:
+// symbolic links are neither followed nor listed. The files are sorted afterwards, so the
+// directories are visited in any order, kept on a stack rather than recursed into.
+func (w *walker) listRegularFiles(root string, files *[]shellFile) error {
+	type pending struct {
+		walkDir
+		rel string
+	}
+	stack := []pending{{walkDir{root, 0}, "."}}
+	for len(stack) > 0 {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if err := w.checkNesting(dir.path, dir.depth); err != nil {
+			return err
+		}
+		contents, err := w.listDir(dir.path)
+		if err != nil {
+			return err
+		}
+		w.reportListed(dir.path, contents)
+
+		for _, x := range contents {
+			switch {
+			case x.IsDir():
+				descend, err := w.descends(x, dir.depth+1)
+				if err != nil {
+					return err
+				}
+				if descend {
+					stack = append(stack, pending{walkDir{w.join(dir.path, x.Name()), dir.depth + 1}, dir.rel + "/" + x.Name()})
+				}
+			case x.Type().IsRegular():
+				info, err := x.Info()
+				if err != nil {
+					return err
+				}
+				*files = append(*files, shellFile{dir.rel + "/" + x.Name(), info})
+			}
+		}
+	}
+	return nil
+}
+
+// sumLine formats a single line of sha256sum output. Like GNU sha256sum, names containing a
+// backslash or a newline are escaped and the line is prefixed with a backslash to say so.
+func sumLine(hash []byte, name string) string {
+	prefix := ""
+	if strings.ContainsAny(name, "\\\n\r") {
+		prefix = "\\"
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+	}
+	return prefix + hex.EncodeToString(hash) + "  " + name + "\n"
+}
@@ -0,0 +1,81 @@
+package dirhash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// shellTree makes a tree whose names need escaping by sha256sum, or sort differently by their
+// bytes than by directory, along with a symbolic link which find -type f leaves out.
+func shellTree(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("names with backslashes and newlines can't be made on Windows")
+	}
+	root := makeTree(t, map[string]string{
+		"back\\slash": "a",
+		"new\nline":   "b",
+		"cr\rx":       "c",
+		"plain.txt":   "plain\n",
+		"sub/B":       "upper",
+		"sub/a":       "lower",
+	})
+	if err := os.Symlink("plain.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// shellListing is what
+//
+//	find . -type f -print0 | LC_ALL=C sort -z | xargs -0 sha256sum
+//
+// printed for shellTree, with GNU coreutils 9.1.
+const shellListing = "\\ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  ./back\\\\slash\n" +
+	"\\2e7d2c03a9507ae265ecf5b5356885a53393a2029d241394997265a1a25aefc6  ./cr\\rx\n" +
+	"\\3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d  ./new\\nline\n" +
+	"dacf36547c7774a0a170806363b5d412991fbc0d6260b2c00b1d3a80a816c23f  ./plain.txt\n" +
+	"aee610558292023758a4229ddcf75f167c9904313a83cf795232ed7f7e2131c9  ./sub/B\n" +
+	"8c6fb1e9e37a1aea1d308c785192e1a17d71cef08c7f50a68d2e0ab292b2e7f4  ./sub/a\n"
+
+func TestShellSortCompat(t *testing.T) {
+	root := shellTree(t)
+	got, err := HashDirWithOptions(root, Options{ShellSortCompat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256([]byte(shellListing))
+	if !bytes.Equal(got, want[:]) {
+		t.Errorf("hash is %X, want %X", got, want)
+	}
+}
+
+// TestShellSortCompatPipeline checks against whatever the shell pipeline prints here and now, if
+// the tools it needs are around.
+func TestShellSortCompatPipeline(t *testing.T) {
+	for _, tool := range []string{"sh", "find", "sort", "xargs", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool, " not found")
+		}
+	}
+	root := shellTree(t)
+	cmd := exec.Command("sh", "-c", "find . -type f -print0 | LC_ALL=C sort -z | xargs -0 sha256sum")
+	cmd.Dir = root
+	listing, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := HashDirWithOptions(root, Options{ShellSortCompat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(listing)
+	if !bytes.Equal(got, want[:]) {
+		t.Errorf("hash is %X, want %X, the hash of\n%s", got, want, listing)
+	}
+}
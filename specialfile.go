@@ -0,0 +1,111 @@
+package dirhash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// SpecialFileMode says what to do about special files found while hashing: named pipes,
+// sockets, and device nodes.
+type SpecialFileMode int
+
+const (
+	// SpecialRead, the default, opens and reads special files like any other, just as HashDir
+	// always has. A device such as /dev/null may read as empty, and one such as /dev/zero never
+	// ends, a named pipe blocks until something writes to it and closes it, and a socket fails
+	// to open at all. PerFileTimeout at least bounds the wait.
+	SpecialRead SpecialFileMode = iota
+
+	// SpecialSkip leaves special files out of the hash entirely, as if they weren't there.
+	SpecialSkip
+
+	// SpecialRecord lists each special file without opening it, with a marker saying what
+	// kind it is in place of a hash, followed by any metadata as for a regular file:
+	//
+	//	fifo "pipe"
+	//	socket "agent.sock"
+	//	chardev "null"
+	//	blockdev "sda"
+	//
+	// No hash is written in lowercase, so the markers can't be mistaken for one. Device
+	// numbers aren't recorded.
+	SpecialRecord
+
+	// SpecialReject fails with ErrSpecialFile on encountering any special file.
+	SpecialReject
+)
+
+var specialFileModeNames = map[SpecialFileMode]string{
+	SpecialRead:   "read",
+	SpecialSkip:   "skip",
+	SpecialRecord: "record",
+	SpecialReject: "reject",
+}
+
+// ParseSpecialFileMode returns the mode with the given name, as returned by
+// SpecialFileMode.String.
+func ParseSpecialFileMode(name string) (SpecialFileMode, error) {
+	for m, n := range specialFileModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown special file mode %q", name)
+}
+
+// String returns the short lowercase name of the mode, such as "skip".
+func (m SpecialFileMode) String() string {
+	if name, ok := specialFileModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SpecialFileMode(%d)", int(m))
+}
+
+// ErrSpecialFile is the underlying error when a special file is refused by SpecialReject.
+var ErrSpecialFile = errors.New("special file not allowed")
+
+// specialMarker returns the marker which SpecialRecord lists a file of the given type with, or
+// "" if it isn't a special file.
+func specialMarker(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "chardev"
+	case mode&fs.ModeDevice != 0:
+		return "blockdev"
+	}
+	return ""
+}
+
+// checkSpecial applies the SpecialFileMode to x, listed at path, returning the entry to hash
+// in its place, or nil to leave it out. A link to a special file which is to be followed is
+// treated as the special file itself.
+func (w *walker) checkSpecial(path string, x fs.DirEntry) (fs.DirEntry, error) {
+	if w.opts.SpecialFiles == SpecialRead {
+		return x, nil
+	}
+	if x.Type()&fs.ModeSymlink != 0 && w.opts.Symlinks == SymlinkFollow {
+		// Whatever is wrong with a link which can't be followed turns up when it is hashed
+		info, err := w.stat(path)
+		if err != nil || specialMarker(info.Mode()) == "" {
+			return x, nil
+		}
+		x = fs.FileInfoToDirEntry(info)
+	}
+	if specialMarker(x.Type()) == "" {
+		return x, nil
+	}
+
+	switch w.opts.SpecialFiles {
+	case SpecialSkip:
+		return nil, nil
+	case SpecialReject:
+		return nil, &os.PathError{Op: "open", Path: path, Err: ErrSpecialFile}
+	}
+	return x, nil
+}
@@ -0,0 +1,68 @@
+package dirhash
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes the work done by a single hash, for Options.Stats.
+type Stats struct {
+	Files   int64         // The number of files hashed
+	Dirs    int64         // The number of directories listed, including the root
+	Bytes   int64         // The bytes read from the contents of the files hashed
+	Skipped int64         // The number of entries skipped under OnError
+	Elapsed time.Duration // How long the whole hash took
+}
+
+// Throughput returns the bytes read per second over the whole hash, or zero if it took no
+// measurable time.
+func (s *Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// countDir adds a directory to the statistics, if they are being kept.
+func (w *walker) countDir() {
+	if w.opts.Stats != nil {
+		atomic.AddInt64(&w.opts.Stats.Dirs, 1)
+	}
+}
+
+// countFile adds a file to the statistics, if they are being kept.
+func (w *walker) countFile() {
+	if w.opts.Stats != nil {
+		atomic.AddInt64(&w.opts.Stats.Files, 1)
+	}
+}
+
+// countRead returns r, counting everything read from it towards the statistics if they are
+// being kept, along with a function to call once reading is done.
+func (w *walker) countRead(r io.Reader) (io.Reader, func()) {
+	if w.opts.Stats == nil {
+		return r, func() {}
+	}
+	counted := &countingReader{r: r}
+	return counted, func() { atomic.AddInt64(&w.opts.Stats.Bytes, counted.n) }
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countSkipped adds a skipped entry to the statistics, if they are being kept.
+func (w *walker) countSkipped() {
+	if w.opts.Stats != nil {
+		atomic.AddInt64(&w.opts.Stats.Skipped, 1)
+	}
+}
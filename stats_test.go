@@ -0,0 +1,29 @@
+package dirhash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStatsFollowedSymlink checks that a link which is followed counts the bytes read from the
+// file it points to, rather than the length of the path it holds.
+func TestStatsFollowedSymlink(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "hello", "sub/target.txt": "longer than the link"})
+	if err := os.Symlink("sub/target.txt", filepath.Join(root, "link")); err != nil {
+		t.Skip("can't make symbolic links: ", err)
+	}
+
+	var stats Stats
+	if _, err := HashDirWithOptions(root, Options{Stats: &stats}); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Files: 3, Dirs: 2, Bytes: int64(len("hello") + 2*len("longer than the link"))}
+	if stats.Files != want.Files || stats.Dirs != want.Dirs || stats.Bytes != want.Bytes {
+		t.Errorf("stats are %d files, %d directories, and %d bytes, want %d, %d, and %d",
+			stats.Files, stats.Dirs, stats.Bytes, want.Files, want.Dirs, want.Bytes)
+	}
+	if stats.Throughput() <= 0 {
+		t.Errorf("throughput is %v, want more than zero", stats.Throughput())
+	}
+}
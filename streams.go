@@ -0,0 +1,51 @@
+package dirhash
+
+import (
+	"fmt"
+	"io"
+)
+
+// streamAttributes returns the attributes recording the alternate data streams of the file or
+// directory at path, under IncludeStreams, each with the hash of its contents.
+func (w *walker) streamAttributes(path string) (string, error) {
+	names, err := listStreams(path)
+	if err != nil {
+		return "", err
+	}
+	var attrs string
+	for _, name := range names {
+		hash, err := w.hashStream(path, name)
+		if err != nil {
+			return "", err
+		}
+		attrs += fmt.Sprintf(" stream=\"%s\":%X", escape(name), hash)
+	}
+	return attrs, nil
+}
+
+// hashStream hashes the contents of the named alternate data stream of the file at path, just
+// as the contents of a file are hashed, taking its place under MaxOpenFiles while it is open.
+func (w *walker) hashStream(path, name string) ([]byte, error) {
+	if err := w.acquireFiles(1); err != nil {
+		return nil, err
+	}
+	defer w.releaseFiles(1)
+	file, err := openStream(path, name)
+	if err != nil {
+		return nil, lockedError(err)
+	}
+	defer file.Close()
+
+	hasher := w.newHash()
+	if w.opts.DomainSeparateNodes {
+		hasher.Write([]byte{leafPrefix})
+	}
+	var source io.Reader = file
+	if w.ctx.Done() != nil {
+		source = contextReader{w.ctx, file}
+	}
+	if _, err := io.Copy(hasher, source); err != nil {
+		return nil, lockedError(err)
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package dirhash
+
+import (
+	"errors"
+	"os"
+)
+
+// listStreams always reports no alternate data streams, since only NTFS on Windows has them.
+func listStreams(path string) ([]string, error) {
+	return nil, nil
+}
+
+// openStream always fails, since there are no alternate data streams to open here.
+func openStream(path, name string) (*os.File, error) {
+	return nil, &os.PathError{Op: "open", Path: path + ":" + name, Err: errors.ErrUnsupported}
+}
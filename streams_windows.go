@@ -0,0 +1,72 @@
+package dirhash
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// findStreamData is WIN32_FIND_STREAM_DATA, describing a single stream of a file.
+type findStreamData struct {
+	size int64
+	name [syscall.MAX_PATH + 36]uint16
+}
+
+// The errors which FindFirstStreamW gives for a file with no streams but its contents, and for
+// a filesystem which has no streams at all, such as FAT.
+const (
+	errorHandleEOF       syscall.Errno = 38
+	errorInvalidFunction syscall.Errno = 1
+	errorNotSupported    syscall.Errno = 50
+	errorInvalidParam    syscall.Errno = 87
+)
+
+// listStreams returns the names of the alternate data streams of the file or directory at path,
+// sorted, leaving out the unnamed stream holding a file's usual contents.
+func listStreams(path string) ([]string, error) {
+	name, err := syscall.UTF16PtrFromString(extendedPath(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "findstreams", Path: path, Err: err}
+	}
+	var data findStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(name)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		switch err {
+		case errorHandleEOF, errorInvalidFunction, errorNotSupported, errorInvalidParam:
+			return nil, nil
+		}
+		return nil, &os.PathError{Op: "findstreams", Path: path, Err: err}
+	}
+	defer syscall.FindClose(syscall.Handle(handle))
+
+	var streams []string
+	for {
+		// Each is named ":name:$DATA", and the usual contents "::$DATA"
+		stream := strings.TrimSuffix(strings.TrimPrefix(syscall.UTF16ToString(data.name[:]), ":"), ":$DATA")
+		if stream != "" {
+			streams = append(streams, stream)
+		}
+		if ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data))); ok == 0 {
+			if err != errorHandleEOF {
+				return nil, &os.PathError{Op: "findstreams", Path: path, Err: err}
+			}
+			break
+		}
+	}
+	sort.Strings(streams)
+	return streams, nil
+}
+
+// openStream opens the named alternate data stream of the file at path for reading, in the same
+// way as openDisk opens the file itself.
+func openStream(path, name string) (*os.File, error) {
+	return openExtended(path+":"+name, extendedPath(path)+":"+name)
+}
@@ -0,0 +1,124 @@
+package dirhash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SymlinkMode says what to do about symbolic links found while hashing.
+type SymlinkMode int
+
+const (
+	// SymlinkFollow, the default, reads through links to files and hashes what they point to,
+	// just as HashDir always has. A link to a directory fails, since it can't be read as a file.
+	// The root itself may be a link to a directory.
+	SymlinkFollow SymlinkMode = iota
+
+	// SymlinkSkip leaves links out of the hash entirely, as if they weren't there. A root
+	// which is itself a link is refused with ErrSymlink, since it can't very well be skipped.
+	SymlinkSkip
+
+	// SymlinkReject fails with ErrSymlink on encountering any link, including the root.
+	SymlinkReject
+
+	// SymlinkHashTarget lists each link as though it were a file containing the path it points
+	// to, exactly as returned by readlink, without looking at the destination at all. This
+	// captures the links themselves, works for links which dangle or point outside the tree,
+	// and doesn't depend on anything beyond the tree. A root which is itself a link is followed.
+	SymlinkHashTarget
+
+	// SymlinkFollowAll reads through every link, including links to directories, which are
+	// hashed as directories in their own right. A link which leads back to a directory
+	// containing it would make the tree infinite, and fails with ErrSymlinkCycle. A link
+	// reached by several paths is hashed afresh each time.
+	SymlinkFollowAll
+)
+
+var symlinkModeNames = map[SymlinkMode]string{
+	SymlinkFollow:     "follow",
+	SymlinkSkip:       "skip",
+	SymlinkReject:     "reject",
+	SymlinkHashTarget: "target",
+	SymlinkFollowAll:  "follow-all",
+}
+
+// ParseSymlinkMode returns the mode with the given name, as returned by SymlinkMode.String.
+func ParseSymlinkMode(name string) (SymlinkMode, error) {
+	for m, n := range symlinkModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown symlink mode %q", name)
+}
+
+// String returns the short lowercase name of the mode, such as "follow".
+func (m SymlinkMode) String() string {
+	if name, ok := symlinkModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SymlinkMode(%d)", int(m))
+}
+
+// ErrSymlink is the underlying error when a symbolic link is refused by the SymlinkMode.
+var ErrSymlink = errors.New("symbolic link not allowed")
+
+// ErrSymlinkCycle is the underlying error when SymlinkFollowAll finds a link leading back to one
+// of the directories containing it.
+var ErrSymlinkCycle = errors.New("symbolic link cycle")
+
+// hashLinkTarget hashes the destination of the link at path, under SymlinkHashTarget.
+func (w *walker) hashLinkTarget(path string) ([]byte, error) {
+	target, err := w.readLink(path)
+	if err != nil {
+		return nil, err
+	}
+	if w.copyTo != "" {
+		if err := w.copyLink(path, target); err != nil {
+			return nil, err
+		}
+	}
+	if w.packTo != nil {
+		if err := w.packLink(path, target); err != nil {
+			return nil, err
+		}
+	}
+	hasher := w.newHash()
+	if w.opts.DomainSeparateNodes {
+		hasher.Write([]byte{leafPrefix})
+	}
+	hasher.Write([]byte(target))
+	return hasher.Sum(nil), nil
+}
+
+// followLink returns the info for whatever the link at path points to, under SymlinkFollowAll,
+// failing if it is a directory which would lead the walk around in a cycle.
+func (w *walker) followLink(path string) (os.FileInfo, error) {
+	info, err := w.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return info, nil
+	}
+
+	// Any cycle has to pass back through one of the directories on the way down to this link,
+	// so it is enough to compare where the link ends up with each of those. They are compared
+	// as files rather than by their real paths, which can't be worked out for paths too long
+	// for the system's own limit on Windows.
+	dir := w.root
+	parts := strings.Split(strings.TrimPrefix(path, w.root+"/"), "/")
+	for _, part := range parts {
+		dirInfo, err := w.stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if os.SameFile(dirInfo, info) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: ErrSymlinkCycle}
+		}
+		dir += "/" + part
+	}
+	return info, nil
+}
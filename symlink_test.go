@@ -0,0 +1,61 @@
+package dirhash
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSymlinkedRoot hashes a tree by way of a link to it, which is followed or refused
+// according to the SymlinkMode.
+func TestSymlinkedRoot(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "beta",
+	})
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Skip("can't make symbolic links: ", err)
+	}
+
+	for _, mode := range []SymlinkMode{SymlinkFollow, SymlinkHashTarget, SymlinkFollowAll} {
+		want, err := HashDirWithOptions(root, Options{Symlinks: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := HashDirWithOptions(link, Options{Symlinks: mode})
+		if err != nil {
+			t.Errorf("%v: %v", mode, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: hash through the link is %X, want %X", mode, got, want)
+		}
+	}
+
+	for _, mode := range []SymlinkMode{SymlinkSkip, SymlinkReject} {
+		if _, err := HashDirWithOptions(link, Options{Symlinks: mode}); !errors.Is(err, ErrSymlink) {
+			t.Errorf("%v: hashing through the link returned %v, want ErrSymlink", mode, err)
+		}
+	}
+}
+
+// TestFollowedSymlinkSize checks that a link which is followed is reported with the size of the
+// file it points to, rather than the length of the path it holds.
+func TestFollowedSymlinkSize(t *testing.T) {
+	root := makeTree(t, map[string]string{"target.txt": "longer than the link"})
+	if err := os.Symlink("target.txt", filepath.Join(root, "link")); err != nil {
+		t.Skip("can't make symbolic links: ", err)
+	}
+
+	sizes := make(map[string]int64)
+	opts := Options{RelativeErrors: true, OnFile: func(e Entry) { sizes[e.Path] = e.Size }}
+	if _, err := HashDirWithOptions(root, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len("longer than the link")); sizes["link"] != want {
+		t.Errorf("followed link has size %d, want %d", sizes["link"], want)
+	}
+}
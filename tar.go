@@ -0,0 +1,329 @@
+package dirhash
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// HashTar hashes the tree contained in a tar archive, giving exactly the same hash as HashDir
+// would for the directory it extracts to, but without writing anything to disk. The archive
+// is read as a stream, so only the hashes of its files are held in memory. Archives compressed
+// with gzip or bzip2 are recognized and decompressed automatically. Those compressed with zstd
+// are recognized too, but not accepted, since the standard library has no zstd decoder; they
+// fail with an error wrapping errors.ErrUnsupported, and must be decompressed beforehand.
+//
+// As with HashDir, symbolic links are hashed as the files they point to, which must be found
+// elsewhere in the archive. Hard links are hashed like the files they link to, and entries
+// which appear more than once replace one another in turn, as they do when extracted. Device
+// files and FIFOs can't be hashed and are rejected, as are entries outside the archive's root.
+func HashTar(r io.Reader) ([]byte, error) {
+	w := newWalker(&Options{})
+	tree := newArchiveTree()
+	if err := w.readTar(tree, r); err != nil {
+		return nil, err
+	}
+	return w.hashArchiveDir(tree.root, tree.root, nil)
+}
+
+// readTar adds every entry of the tar archive read from r, compressed or otherwise, to tree.
+func (w *walker) readTar(tree *archiveTree, r io.Reader) error {
+	contents, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(contents)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			// Read right to the end, past the padding, in case r has anything to check there
+			if _, err := io.Copy(io.Discard, contents); err != nil {
+				return err
+			}
+			_, err = io.Copy(io.Discard, r)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.addTarEntry(tree, header, archive); err != nil {
+			return err
+		}
+	}
+}
+
+// decompress returns the uncompressed contents of r, recognizing the compression used, if any,
+// from the first few bytes.
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(buffered), nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("zstd-compressed archives: %w", errors.ErrUnsupported)
+	}
+	return buffered, nil
+}
+
+// archiveTree is the tree of entries read from one or more archives.
+type archiveTree struct {
+	root *archiveNode
+
+	// layer counts the archives read so far when they are image layers, each applied on top of
+	// those before it, with whiteout files deleting entries from the layers below.
+	layer     int
+	whiteouts bool
+}
+
+func newArchiveTree() *archiveTree {
+	return &archiveTree{root: &archiveNode{children: make(map[string]*archiveNode)}}
+}
+
+// archiveNode is a single entry of an archive, once the archive has been read.
+type archiveNode struct {
+	children map[string]*archiveNode // The entries in a directory, or nil for anything else
+	hash     []byte                  // The hash of a regular file
+	link     string                  // The destination of a symbolic link
+	layer    int                     // The layer the entry was last written by
+}
+
+// The names marking entries deleted by an image layer, as the OCI image spec defines them.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq" // Hides everything the layers below put in the directory
+)
+
+// addTarEntry adds the entry described by header to tree, hashing the contents of a regular
+// file as they are read from archive.
+func (w *walker) addTarEntry(tree *archiveTree, header *tar.Header, archive io.Reader) error {
+	root := tree.root
+	parts, err := archivePath(header.Name)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return nil // The root itself
+	}
+	dir, err := archiveDir(tree, parts[:len(parts)-1], header.Name)
+	if err != nil {
+		return err
+	}
+	name := parts[len(parts)-1]
+
+	if tree.whiteouts && strings.HasPrefix(name, whiteoutPrefix) {
+		if name == whiteoutOpaque {
+			for child, node := range dir.children {
+				if node.layer < tree.layer {
+					delete(dir.children, child)
+				}
+			}
+		} else {
+			delete(dir.children, strings.TrimPrefix(name, whiteoutPrefix))
+		}
+		return nil
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		tree.addDir(dir, name)
+	case tar.TypeReg:
+		return w.addArchiveFile(tree, dir, name, archive)
+	case tar.TypeSymlink:
+		dir.children[name] = &archiveNode{link: header.Linkname, layer: tree.layer}
+	case tar.TypeLink:
+		targetParts, err := archivePath(header.Linkname)
+		if err != nil {
+			return err
+		}
+		target, _, err := resolveArchivePath(root, nil, targetParts, 0)
+		if err != nil || target.hash == nil {
+			return fmt.Errorf("%s: hard link to %s, which is not a file earlier in the archive", header.Name, header.Linkname)
+		}
+		dir.children[name] = &archiveNode{hash: target.hash, layer: tree.layer}
+	default:
+		return fmt.Errorf("%s: unsupported archive entry type %q", header.Name, header.Typeflag)
+	}
+	return nil
+}
+
+// addDir adds the directory called name to dir, keeping whatever is in it already if it was
+// added before.
+func (tree *archiveTree) addDir(dir *archiveNode, name string) {
+	if existing := dir.children[name]; existing == nil || existing.children == nil {
+		dir.children[name] = &archiveNode{children: make(map[string]*archiveNode)}
+	}
+	dir.children[name].layer = tree.layer
+}
+
+// addArchiveFile adds the regular file called name to dir, hashing its contents as they are
+// read from r.
+func (w *walker) addArchiveFile(tree *archiveTree, dir *archiveNode, name string, r io.Reader) error {
+	hasher := w.newHash()
+	if w.opts.DomainSeparateNodes {
+		hasher.Write([]byte{leafPrefix})
+	}
+	if _, err := io.Copy(hasher, r); err != nil {
+		return err
+	}
+	dir.children[name] = &archiveNode{hash: hasher.Sum(nil), layer: tree.layer}
+	return nil
+}
+
+// archivePath splits the name of an entry in an archive into its components, relative to the
+// root of the archive, failing if it lies outside the root.
+func archivePath(name string) ([]string, error) {
+	clean := path.Clean(strings.TrimLeft(name, "/"))
+	if clean == "." {
+		return nil, nil
+	}
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, fmt.Errorf("%s: archive entry outside the root", name)
+	}
+	return strings.Split(clean, "/"), nil
+}
+
+// archiveDir returns the directory within tree with the given path, creating it and any
+// directories above it which the archive hasn't listed explicitly, as extracting it would.
+func archiveDir(tree *archiveTree, parts []string, name string) (*archiveNode, error) {
+	dir := tree.root
+	for _, part := range parts {
+		child := dir.children[part]
+		if child == nil {
+			child = &archiveNode{children: make(map[string]*archiveNode), layer: tree.layer}
+			dir.children[part] = child
+		}
+		if child.children == nil {
+			return nil, fmt.Errorf("%s: parent is not a directory", name)
+		}
+		dir = child
+	}
+	return dir, nil
+}
+
+// maxArchiveLinks is how many symbolic links may be followed in resolving a single path, as a
+// guard against cycles, matching the usual limit on Linux.
+const maxArchiveLinks = 40
+
+// resolveArchivePath finds the entry which the relative path parts leads to from the directory
+// at dirPath within root, following any symbolic links along the way. It also returns the path
+// of that entry, with every link resolved.
+func resolveArchivePath(root *archiveNode, dirPath, parts []string, links int) (*archiveNode, []string, error) {
+	current := append([]string(nil), dirPath...)
+	node := archiveLookup(root, current)
+	for _, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(current) == 0 {
+				return nil, nil, errors.New("symbolic link leads outside the archive")
+			}
+			current = current[:len(current)-1]
+			node = archiveLookup(root, current)
+			continue
+		}
+		if node.children == nil {
+			return nil, nil, errors.New("not a directory")
+		}
+		child := node.children[part]
+		if child == nil {
+			return nil, nil, errors.New("no such file in the archive")
+		}
+		if child.link == "" {
+			node, current = child, append(current, part)
+			continue
+		}
+
+		if links++; links > maxArchiveLinks {
+			return nil, nil, errors.New("too many levels of symbolic links")
+		}
+		if strings.HasPrefix(child.link, "/") {
+			return nil, nil, errors.New("symbolic link leads outside the archive")
+		}
+		var err error
+		node, current, err = resolveArchivePath(root, current, strings.Split(child.link, "/"), links)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return node, current, nil
+}
+
+// archiveLookup returns the entry at the given path within root, which must contain no links.
+func archiveLookup(root *archiveNode, parts []string) *archiveNode {
+	node := root
+	for _, part := range parts {
+		node = node.children[part]
+	}
+	return node
+}
+
+// hashArchiveDir hashes the directory dir, found at dirPath within root, just as hashDir would
+// hash it once extracted.
+func (w *walker) hashArchiveDir(root, dir *archiveNode, dirPath []string) ([]byte, error) {
+	names := make([]string, 0, len(dir.children))
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]dirEntry, 0, len(names))
+	for _, name := range names {
+		node := dir.children[name]
+		entry := dirEntry{name: name}
+		switch {
+		case node.link == "":
+		case w.opts.Symlinks == SymlinkSkip:
+			continue
+		case w.opts.Symlinks == SymlinkReject:
+			return nil, &os.PathError{Op: "open", Path: path.Join(append(dirPath, name)...), Err: ErrSymlink}
+		case w.opts.Symlinks == SymlinkHashTarget:
+			hasher := w.newHash()
+			if w.opts.DomainSeparateNodes {
+				hasher.Write([]byte{leafPrefix})
+			}
+			hasher.Write([]byte(node.link))
+			entry.hash = fmt.Sprintf("%X", hasher.Sum(nil))
+			entries = append(entries, entry)
+			continue
+		default:
+			target, _, err := resolveArchivePath(root, dirPath, []string{name}, 0)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path.Join(append(dirPath, name)...), err)
+			}
+			if target.children != nil {
+				return nil, fmt.Errorf("%s: symbolic link to a directory", path.Join(append(dirPath, name)...))
+			}
+			node = target
+		}
+
+		if node.children != nil {
+			entry.dir = true
+			subPath := append(append([]string(nil), dirPath...), name)
+			sum, err := w.hashArchiveDir(root, node, subPath)
+			if err != nil {
+				return nil, err
+			}
+			entry.hash = fmt.Sprintf("%X", sum)
+		} else {
+			entry.hash = fmt.Sprintf("%X", node.hash)
+		}
+		entries = append(entries, entry)
+	}
+	return w.hashPseudoFile(func(out io.Writer) { writeEntries(out, entries) }), nil
+}
@@ -0,0 +1,15 @@
+package dirhash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHashTarZstd(t *testing.T) {
+	// Just the zstd frame magic is enough to be recognized, and refused
+	archive := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00, 0x00, 0x00}
+	if _, err := HashTar(bytes.NewReader(archive)); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("hashing zstd archive: got %v, want errors.ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,295 @@
+package dirhash
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Node is a file or directory within a hashed tree, along with its hash.
+type Node struct {
+	Name     string   // The name of the entry within its directory, or for the root the path hashed
+	Type     NodeType // What kind of entry it is
+	Hash     []byte   // The hash listed for the entry in its directory, or for the root the whole hash
+	Size     int64    // The size of a file in bytes, or the total size of every file beneath a directory
+	Children []*Node  // The contents of a directory, in order of name
+}
+
+// NodeType says what kind of entry a Node is.
+type NodeType int
+
+const (
+	NodeFile    NodeType = iota // A regular file, or a link followed to one
+	NodeDir                     // A directory, or a link followed to one
+	NodeSymlink                 // A symbolic link hashed by its target, under SymlinkHashTarget
+)
+
+// Child returns the entry with the given name in a directory, or nil if there isn't one.
+func (n *Node) Child(name string) *Node {
+	i := sort.Search(len(n.Children), func(i int) bool { return n.Children[i].Name >= name })
+	if i < len(n.Children) && n.Children[i].Name == name {
+		return n.Children[i]
+	}
+	return nil
+}
+
+// Tree hashes the directory at path like HashDir, but rather than only the hash of the root,
+// returns the whole tree of entries beneath it, each with its own hash. The root's hash is the
+// same one HashDir returns.
+func Tree(path string) (*Node, error) {
+	return TreeWithOptions(path, Options{})
+}
+
+// TreeWithOptions is like Tree, but hashes the directory according to opts. ShellSortCompat and
+// ContentOnly can't be used, since neither gives directories hashes, and under CollapseChains a
+// directory standing in for its only subdirectory has that subdirectory's hash.
+func TreeWithOptions(path string, opts Options) (*Node, error) {
+	if opts.ShellSortCompat || opts.ContentOnly {
+		return nil, errors.New("cannot build a tree in ShellSortCompat or ContentOnly mode")
+	}
+	root, err := buildTree(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	root.Name = path
+	return root, nil
+}
+
+// buildTree hashes the directory at path, returning the whole tree of hashes beneath it.
+func buildTree(path string, opts Options) (*Node, error) {
+	// There aren't any directory hashes to record in a flat listing
+	opts.ShellSortCompat, opts.ContentOnly = false, false
+	var mu sync.Mutex
+	nodes := make(map[string]*Node)
+	record := func(dir string, node *Node) {
+		rel := ""
+		if dir != path {
+			rel = strings.TrimPrefix(dir, path+"/")
+		}
+		node.Name = rel[strings.LastIndex(rel, "/")+1:]
+		mu.Lock()
+		nodes[rel] = node
+		mu.Unlock()
+	}
+
+	w := newWalker(&opts)
+	w.onFile = func(file string, size int64, link bool, hash []byte) error {
+		node := &Node{Type: NodeFile, Hash: hash, Size: size}
+		if link {
+			node.Type = NodeSymlink
+		}
+		record(file, node)
+		return nil
+	}
+	w.onDir = func(dir string, hash []byte) error {
+		record(dir, &Node{Type: NodeDir, Hash: hash})
+		return nil
+	}
+	if _, err := w.run(path); err != nil {
+		return nil, err
+	}
+
+	// Hang every node from its parent, now that they've all turned up
+	root := nodes[""]
+	for rel, node := range nodes {
+		if rel == "" {
+			continue
+		}
+		parent := ""
+		if i := strings.LastIndex(rel, "/"); i >= 0 {
+			parent = rel[:i]
+		}
+		if p := nodes[parent]; p != nil {
+			p.Children = append(p.Children, node)
+		}
+	}
+	for _, node := range nodes {
+		sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Name < node.Children[j].Name })
+	}
+	totalSize(root)
+	return root, nil
+}
+
+// totalSize fills in the size of every directory in the tree beneath n, returning the size of n.
+func totalSize(n *Node) int64 {
+	if n.Type == NodeDir {
+		n.Size = 0
+		for _, child := range n.Children {
+			n.Size += totalSize(child)
+		}
+	}
+	return n.Size
+}
+
+// DiffDirs hashes the directories at a and b and lists every path at which they differ. Files
+// which only exist in b are Added, those only in a are Removed, and those in both with different
+// contents are Modified. A directory which only exists on one side is listed once, with a
+// trailing slash, rather than file by file. An entry which is a file on one side and a
+// directory on the other counts as Modified.
+func DiffDirs(a, b string) (*ManifestDiff, error) {
+	return DiffDirsWithOptions(a, b, Options{})
+}
+
+// DiffDirsWithOptions is like DiffDirs, but hashes both directories according to opts.
+func DiffDirsWithOptions(a, b string, opts Options) (*ManifestDiff, error) {
+	treeA, err := buildTree(a, opts)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := buildTree(b, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff ManifestDiff
+	diffTrees(treeA, treeB, "", &diff)
+	sort.Strings(diff.Modified)
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return &diff, nil
+}
+
+// diffTrees adds the differences between the directories a and b, found at the relative path
+// prefix, to diff. Subdirectories with identical hashes are identical throughout, and so are
+// never looked into.
+func diffTrees(a, b *Node, prefix string, diff *ManifestDiff) {
+	if bytes.Equal(a.Hash, b.Hash) {
+		return
+	}
+	for _, x := range a.Children {
+		y := b.Child(x.Name)
+		switch {
+		case y == nil:
+			diff.Removed = append(diff.Removed, prefix+displayName(x))
+		case (x.Type == NodeDir) != (y.Type == NodeDir):
+			diff.Modified = append(diff.Modified, prefix+x.Name)
+		case x.Type == NodeDir:
+			diffTrees(x, y, prefix+x.Name+"/", diff)
+		case !bytes.Equal(x.Hash, y.Hash):
+			diff.Modified = append(diff.Modified, prefix+x.Name)
+		}
+	}
+	for _, y := range b.Children {
+		if a.Child(y.Name) == nil {
+			diff.Added = append(diff.Added, prefix+displayName(y))
+		}
+	}
+}
+
+// displayName returns the name of a node, with a trailing slash if it is a directory.
+func displayName(n *Node) string {
+	if n.Type == NodeDir {
+		return n.Name + "/"
+	}
+	return n.Name
+}
+
+// WriteTree writes the hierarchy of the directory at path to w, drawn as the 'tree' command
+// draws it, with the hash of every file and directory beside its name:
+//
+//	07D341353E04015E848F33F2F011FE7505D13B084F896BA623CE5BDECA2BBADC  dir/
+//	40A152D1A4AA6D2AD79D4F25E6699F98975660286CF99C6330AC82D63E2F510F  ├── sub/
+//	A3A5E715F0CC574A73C3F9BEBB6BC24F32FFD5B67B387244C2C909DA779A1478  │   └── a.txt
+//	87428FC522803D31065E7BCE3CF03FE475096631E5E07BBD7A0FDE60C4CF25C7  └── b.txt
+//
+// Comparing the trees of two copies of a directory shows which subtrees account for any
+// difference between their hashes. Names which contain anything unprintable are quoted.
+func WriteTree(path string, w io.Writer) error {
+	return WriteTreeWithOptions(path, w, Options{})
+}
+
+// WriteTreeWithOptions is like WriteTree, but hashes the directory according to opts.
+// ShellSortCompat and ContentOnly can't be used, since neither gives directories hashes.
+func WriteTreeWithOptions(path string, w io.Writer, opts Options) error {
+	root, err := drawableTree(path, opts)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	var draw func(n *Node, indent, branch string)
+	draw = func(n *Node, indent, branch string) {
+		fmt.Fprintf(out, "%X  %s%s\n", n.Hash, indent+branch, treeName(n))
+		switch branch {
+		case "├── ":
+			indent += "│   "
+		case "└── ":
+			indent += "    "
+		}
+		for i, child := range n.Children {
+			if i == len(n.Children)-1 {
+				draw(child, indent, "└── ")
+			} else {
+				draw(child, indent, "├── ")
+			}
+		}
+	}
+	draw(root, "", "")
+	return out.Flush()
+}
+
+// WriteTreeDOT writes the tree of the directory at path to w as a Graphviz DOT graph, hashing
+// it according to opts as WriteTreeWithOptions does. Each node is labelled with its name and
+// the first eight digits of its hash, and has an edge to each entry if it is a directory.
+func WriteTreeDOT(path string, w io.Writer, opts Options) error {
+	root, err := drawableTree(path, opts)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "digraph dirhash {\n\tnode [shape=box, fontname=monospace];\n")
+	var id int
+	var draw func(n *Node) int
+	draw = func(n *Node) int {
+		self := id
+		id++
+		shape := ""
+		if n.Type == NodeDir {
+			shape = ", shape=folder"
+		}
+		label := fmt.Sprintf("%s\\n%.4X", dotEscape(treeName(n)), n.Hash)
+		fmt.Fprintf(out, "\tn%d [label=\"%s\"%s];\n", self, label, shape)
+		for _, child := range n.Children {
+			fmt.Fprintf(out, "\tn%d -> n%d;\n", self, draw(child))
+		}
+		return self
+	}
+	draw(root)
+	fmt.Fprintf(out, "}\n")
+	return out.Flush()
+}
+
+// drawableTree hashes the directory at path into a tree for WriteTree or WriteTreeDOT.
+func drawableTree(path string, opts Options) (*Node, error) {
+	root, err := TreeWithOptions(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	root.Name = strings.TrimSuffix(path, "/")
+	return root, nil
+}
+
+// dotEscape escapes x for use within a quoted DOT string.
+func dotEscape(x string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(x)
+}
+
+// treeName returns the name of a node as WriteTree displays it.
+func treeName(n *Node) string {
+	name := n.Name
+	if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		name = strconv.Quote(name)
+	}
+	if n.Type == NodeDir {
+		name += "/"
+	}
+	return name
+}
@@ -0,0 +1,79 @@
+package dirhash
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// uringEntries is the number of reads the ring under IOUring holds in flight at once, which is
+// more than any sensible FileConcurrency.
+const uringEntries = 64
+
+// uringMaxSize is the largest file read through the ring under IOUring. Larger files are read
+// as usual, since their time goes into reading data rather than into the calls reading it.
+const uringMaxSize = 1 << 20
+
+// startRing sets up the ring which files are read through under IOUring, if it is set, and
+// returns the function which shuts it down again. Where io_uring can't be used, whether because
+// this isn't Linux or because the kernel doesn't allow it, files are simply read as usual.
+func (w *walker) startRing() func() {
+	if !w.opts.IOUring {
+		return func() {}
+	}
+	ring, err := newUring(uringEntries)
+	if err != nil {
+		if w.logEnabled(slog.LevelInfo) {
+			w.opts.Logger.InfoContext(w.ctx, "io_uring unavailable, reading files as usual", "error", err)
+		}
+		return func() {}
+	}
+	w.ring.Store(ring)
+	return func() {
+		w.ring.CompareAndSwap(ring, nil)
+		ring.stop()
+	}
+}
+
+// ringContents reads the whole of file, opened from path, through the ring under IOUring,
+// returning a reader for its contents. Only small regular files on the local disk are read
+// through the ring; for anything else it returns nil, and the file is read as usual. A file
+// which turns out to be longer than it was a moment ago is read on from where the ring left
+// off, so nothing is missed.
+func (w *walker) ringContents(path string, file fs.File) (io.Reader, error) {
+	ring := w.ring.Load()
+	if ring == nil {
+		return nil, nil
+	}
+	f, ok := diskFile(file)
+	if !ok {
+		return nil, nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 || info.Size() > uringMaxSize {
+		return nil, nil
+	}
+
+	// One byte more than the file should hold shows whether it has reached the end
+	buf := make([]byte, info.Size()+1)
+	n, err := ring.read(int(f.Fd()), buf)
+	if err == errRingClosed {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: path, Err: err}
+	}
+	if int64(n) == info.Size() {
+		return bytes.NewReader(buf[:n]), nil
+	}
+	if _, err := f.Seek(int64(n), io.SeekStart); err != nil {
+		return nil, err
+	}
+	var rest io.Reader = file
+	if w.ctx.Done() != nil {
+		rest = contextReader{w.ctx, file}
+	}
+	return io.MultiReader(bytes.NewReader(buf[:n]), rest), nil
+}
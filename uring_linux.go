@@ -0,0 +1,384 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package dirhash
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The system calls and constants for io_uring, which the syscall package doesn't have. The
+// call numbers are the same on every architecture but MIPS, where io_uring isn't used.
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	uringEnterGetEvents = 1 << 0
+	uringFeatSingleMmap = 1 << 0
+	uringFeatRWCurPos   = 1 << 3 // New in Linux 5.6, along with uringOpRead
+	uringOpRead         = 22
+	uringOpAsyncCancel  = 14
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+)
+
+// uringParams is struct io_uring_params, as passed to io_uring_setup.
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+// uringSQOffsets is struct io_sqring_offsets, giving where each field of the submission ring is
+// within its mapping.
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringCQOffsets is struct io_cqring_offsets, giving where each field of the completion ring is
+// within its mapping.
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringSQE is struct io_uring_sqe, a single request submitted to the kernel.
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+// uringCQE is struct io_uring_cqe, the kernel's report of a request it has completed.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// errRingClosed is returned by read once the ring has been shut down, for a file to be read as
+// usual instead.
+var errRingClosed = errors.New("io_uring closed")
+
+// A uring reads files asynchronously through io_uring. Reads asked for by any number of
+// goroutines are gathered up by a single goroutine serving the ring, which submits as many as
+// are waiting in one system call, and waits in the same call for any of them to complete. With
+// many goroutines reading small files, as under a high FileConcurrency, one system call then
+// does the work of many reads, and the kernel works on them all at once.
+type uring struct {
+	fd       int
+	rings    [][]byte // The mappings of the rings, which are one and the same under uringFeatSingleMmap
+	sqes     []byte
+	sqHead   *uint32
+	sqTail   *uint32
+	sqMask   uint32
+	sqArray  []uint32
+	cqHead   *uint32
+	cqTail   *uint32
+	cqMask   uint32
+	cqes     []uringCQE
+	requests chan *uringRead
+
+	// wake is a pipe, the reading end of which is always being read through the ring, so that
+	// writing to the other wakes the serving goroutine from waiting on the kernel. wakeBuf is
+	// what the byte written is read into.
+	wake    [2]int
+	wakeBuf [1]byte
+
+	// mu guards closed, which is held by readers while sending a request so that requests is
+	// never sent to once it has been closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// A uringRead is a single read waiting for the ring.
+type uringRead struct {
+	fd   int
+	buf  []byte
+	res  int32
+	done chan struct{}
+}
+
+// newUring sets up a ring for up to entries reads in flight at once, and starts serving it.
+func newUring(entries uint32) (*uring, error) {
+	var params uringParams
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &uring{fd: int(fd), wake: [2]int{-1, -1}}
+	if params.features&uringFeatRWCurPos == 0 {
+		r.unmap()
+		return nil, errors.New("io_uring too old to read files, before Linux 5.6")
+	}
+	if err := r.mapRings(&params); err != nil {
+		r.unmap()
+		return nil, err
+	}
+	if err := syscall.Pipe2(r.wake[:], syscall.O_CLOEXEC); err != nil {
+		r.wake = [2]int{-1, -1}
+		r.unmap()
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	r.requests = make(chan *uringRead, params.sqEntries)
+	go r.serve(int(params.sqEntries))
+	return r, nil
+}
+
+// mapRings maps the submission and completion rings, and the submitted requests, into memory.
+func (r *uring) mapRings(params *uringParams) error {
+	sqSize := int(params.sqOff.array + params.sqEntries*4)
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	if params.features&uringFeatSingleMmap != 0 {
+		sqSize = max(sqSize, cqSize)
+	}
+	sqRing, err := syscall.Mmap(r.fd, uringOffSQRing, sqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return os.NewSyscallError("mmap", err)
+	}
+	r.rings = append(r.rings, sqRing)
+	cqRing := sqRing
+	if params.features&uringFeatSingleMmap == 0 {
+		if cqRing, err = syscall.Mmap(r.fd, uringOffCQRing, cqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+			return os.NewSyscallError("mmap", err)
+		}
+		r.rings = append(r.rings, cqRing)
+	}
+	sqeSize := int(params.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqes, err = syscall.Mmap(r.fd, uringOffSQEs, sqeSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		return os.NewSyscallError("mmap", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&sqRing[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&sqRing[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&sqRing[params.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&sqRing[params.sqOff.array])), params.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&cqRing[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&cqRing[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&cqRing[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&cqRing[params.cqOff.cqes])), params.cqEntries)
+	return nil
+}
+
+// unmap releases the mappings of the rings and closes the ring itself, along with its pipe.
+func (r *uring) unmap() {
+	for _, ring := range r.rings {
+		syscall.Munmap(ring)
+	}
+	if r.sqes != nil {
+		syscall.Munmap(r.sqes)
+	}
+	syscall.Close(r.fd)
+	for _, fd := range r.wake {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
+
+// read reads from the start of the file open as fd into buf through the ring, waiting until
+// the read is complete, and returns how much was read.
+func (r *uring) read(fd int, buf []byte) (int, error) {
+	req := &uringRead{fd: fd, buf: buf, done: make(chan struct{})}
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return 0, errRingClosed
+	}
+	r.requests <- req
+	r.mu.RUnlock()
+
+	<-req.done
+	if req.res < 0 {
+		return 0, syscall.Errno(-req.res)
+	}
+	return int(req.res), nil
+}
+
+// stop shuts the ring down, without waiting for any reads still in flight, which can only be
+// those of goroutines abandoned under PerFileTimeout once the hash is over. Those reads are
+// cancelled where the kernel allows, and the ring itself is closed in the background once the
+// last of them completes, so that a read which never returns can't hold up the hash. Any read
+// asked for afterwards returns errRingClosed, and the file is read as usual instead.
+func (r *uring) stop() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.requests)
+		syscall.Write(r.wake[1], []byte{0})
+	}
+	r.mu.Unlock()
+}
+
+// serve submits the reads sent to requests, and reports each back as it completes, until
+// requests is closed and nothing is left in flight. It waits for new reads only while nothing
+// is in flight, and otherwise for the kernel to complete something, picking up whatever has
+// been sent in the meantime each time around. Once requests is closed, everything still in
+// flight is cancelled.
+//
+// The read of the wake pipe goes under the id zero, which is never that of any other read, and
+// is passed over by reap along with the completions of any cancellations.
+func (r *uring) serve(entries int) {
+	inFlight := make(map[uint64]*uringRead)
+	var next uint64
+	var lost [][]byte // The buffers of reads given up on, which the kernel might yet write to
+	open, failed, cancelled, woken := true, false, false, false
+	r.push(0, &uringRead{fd: r.wake[0], buf: r.wakeBuf[:]})
+	failed = r.enter(false) != nil
+
+	// The kernel may write to wakeBuf until the read of the wake pipe completes, so it is waited
+	// for even once nothing else is left
+	finished := func() bool { return !open && len(inFlight) == 0 && (woken || failed) }
+	for !finished() {
+		if open && len(inFlight) == 0 {
+			if req, ok := <-r.requests; ok {
+				next++
+				inFlight[next] = req
+				r.push(next, req)
+			} else {
+				open = false
+			}
+		}
+	gather:
+		for open && len(inFlight) < entries {
+			select {
+			case req, ok := <-r.requests:
+				if !ok {
+					open = false
+					break gather
+				}
+				next++
+				inFlight[next] = req
+				r.push(next, req)
+			default:
+				break gather
+			}
+		}
+
+		if !open && !cancelled && !failed {
+			// Once everything pushed so far has been submitted, there is room to cancel
+			// whatever is still in flight
+			if r.enter(false) == nil {
+				for id := range inFlight {
+					next++
+					r.pushCancel(next, id)
+				}
+			}
+			cancelled = true
+		}
+		if finished() {
+			break
+		}
+
+		if failed || r.enter(true) != nil {
+			// Something is badly wrong with the ring, so every read fails from here on
+			failed = true
+			for id, req := range inFlight {
+				lost = append(lost, req.buf)
+				req.res = -int32(syscall.EIO)
+				close(req.done)
+				delete(inFlight, id)
+			}
+			continue
+		}
+		woken = r.reap(inFlight) || woken
+	}
+	r.unmap()
+	runtime.KeepAlive(lost)
+}
+
+// push adds a read of req to the submission ring, under the given id.
+func (r *uring) push(id uint64, req *uringRead) {
+	tail := atomic.LoadUint32(r.sqTail)
+	index := tail & r.sqMask
+	sqe := (*uringSQE)(unsafe.Pointer(&r.sqes[uintptr(index)*unsafe.Sizeof(uringSQE{})]))
+	*sqe = uringSQE{
+		opcode:   uringOpRead,
+		fd:       int32(req.fd),
+		addr:     uint64(uintptr(unsafe.Pointer(unsafe.SliceData(req.buf)))),
+		len:      uint32(len(req.buf)),
+		userData: id,
+	}
+	r.sqArray[index] = index
+	atomic.StoreUint32(r.sqTail, tail+1)
+}
+
+// pushCancel adds a cancellation of the read in flight under target to the submission ring,
+// under the given id of its own.
+func (r *uring) pushCancel(id, target uint64) {
+	tail := atomic.LoadUint32(r.sqTail)
+	index := tail & r.sqMask
+	sqe := (*uringSQE)(unsafe.Pointer(&r.sqes[uintptr(index)*unsafe.Sizeof(uringSQE{})]))
+	*sqe = uringSQE{
+		opcode:   uringOpAsyncCancel,
+		fd:       -1,
+		addr:     target,
+		userData: id,
+	}
+	r.sqArray[index] = index
+	atomic.StoreUint32(r.sqTail, tail+1)
+}
+
+// enter submits everything pushed which the kernel hasn't yet taken, and if wait is set, waits
+// for at least one read to complete.
+func (r *uring) enter(wait bool) error {
+	minComplete, flags := 0, 0
+	if wait {
+		minComplete, flags = 1, uringEnterGetEvents
+	}
+	for {
+		pending := atomic.LoadUint32(r.sqTail) - atomic.LoadUint32(r.sqHead)
+		_, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd), uintptr(pending), uintptr(minComplete), uintptr(flags), 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR, syscall.EAGAIN, syscall.EBUSY:
+			continue
+		default:
+			return os.NewSyscallError("io_uring_enter", errno)
+		}
+	}
+}
+
+// reap reports back every read the kernel has completed, and reports whether the read of the
+// wake pipe was among them.
+func (r *uring) reap(inFlight map[uint64]*uringRead) (woken bool) {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	for ; head != tail; head++ {
+		cqe := r.cqes[head&r.cqMask]
+		woken = woken || cqe.userData == 0
+		if req, ok := inFlight[cqe.userData]; ok {
+			req.res = cqe.res
+			close(req.done)
+			delete(inFlight, cqe.userData)
+		}
+	}
+	atomic.StoreUint32(r.cqHead, head)
+	return woken
+}
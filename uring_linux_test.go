@@ -0,0 +1,77 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package dirhash
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUringRead(t *testing.T) {
+	ring, err := newUring(uringEntries)
+	if err != nil {
+		t.Skip("io_uring unavailable: ", err)
+	}
+	defer ring.stop()
+
+	path := makeTree(t, map[string]string{"f": "through the ring"}) + "/f"
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	buf := make([]byte, 64)
+	n, err := ring.read(int(file.Fd()), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte("through the ring")) {
+		t.Errorf("read %q", buf[:n])
+	}
+}
+
+// TestUringStopHung stops the ring while a read is stuck waiting on a pipe which is never
+// written to, which mustn't hold up stop, and which is then cancelled.
+func TestUringStopHung(t *testing.T) {
+	ring, err := newUring(uringEntries)
+	if err != nil {
+		t.Skip("io_uring unavailable: ", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := ring.read(int(r.Fd()), make([]byte, 1))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		ring.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop waited for a hung read")
+	}
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Error("hung read succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("hung read was never cancelled")
+	}
+	if _, err := ring.read(int(r.Fd()), make([]byte, 1)); err != errRingClosed {
+		t.Errorf("read after stop returned %v, want errRingClosed", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+
+package dirhash
+
+import "errors"
+
+// errRingClosed is returned by read once the ring has been shut down, which it always is here.
+var errRingClosed = errors.New("io_uring closed")
+
+// A uring stands in for the io_uring ring of Linux, which isn't available here.
+type uring struct{}
+
+// newUring always fails, since io_uring is only available on Linux.
+func newUring(entries uint32) (*uring, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (r *uring) read(fd int, buf []byte) (int, error) {
+	return 0, errRingClosed
+}
+
+func (r *uring) stop() {}
@@ -0,0 +1,58 @@
+package dirhash
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// WalkEntry is a single file or directory reported by Walk, once it has been hashed.
+type WalkEntry struct {
+	Path string   // The path of the entry, beginning with the path being hashed unless RelativeErrors is set
+	Type NodeType // What kind of entry it is
+	Hash []byte   // The hash listed for the entry in its directory, or for the root the whole hash
+	Size int64    // The size of a file in bytes, or the total size of every file beneath a directory
+}
+
+// Walk hashes the directory at path according to opts, as HashDirWithOptions does, and calls fn
+// with every file and directory as soon as it has been hashed, so that even enormous trees can
+// be processed without holding all of their hashes at once. Each directory is reported after
+// everything in it, and the root last of all. With DirConcurrency and FileConcurrency both 1,
+// entries arrive depth-first in order of name; otherwise they arrive in whatever order they
+// finish, but fn is never called concurrently. If fn returns an error the walk stops, and Walk
+// returns that error. ShellSortCompat and ContentOnly can't be used, as for TreeWithOptions.
+func Walk(path string, opts Options, fn func(WalkEntry) error) ([]byte, error) {
+	if opts.ShellSortCompat || opts.ContentOnly {
+		return nil, errors.New("cannot walk a tree in ShellSortCompat or ContentOnly mode")
+	}
+
+	// Only the directories still being hashed have their sizes kept, each growing as the
+	// entries in it are reported
+	w := newWalker(&opts)
+	var mu sync.Mutex
+	sizes := make(map[string]int64)
+	report := func(entryPath string, entry WalkEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if entry.Type == NodeDir {
+			entry.Size = sizes[entryPath]
+			delete(sizes, entryPath)
+		}
+		if entryPath != w.root {
+			sizes[entryPath[:strings.LastIndex(entryPath, "/")]] += entry.Size
+		}
+		return fn(entry)
+	}
+
+	w.onFile = func(file string, size int64, link bool, hash []byte) error {
+		entry := WalkEntry{Path: w.display(file), Type: NodeFile, Hash: hash, Size: size}
+		if link {
+			entry.Type = NodeSymlink
+		}
+		return report(file, entry)
+	}
+	w.onDir = func(dir string, hash []byte) error {
+		return report(dir, WalkEntry{Path: w.display(dir), Type: NodeDir, Hash: hash})
+	}
+	return w.run(path)
+}
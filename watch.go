@@ -0,0 +1,62 @@
+package dirhash
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// Watch hashes the directory at path every interval until ctx is done, calling onChange with
+// the new hash whenever it differs from the last one, starting with the first. Between polls
+// the hash of every file is remembered in memory, keyed as in a HashCache, so each poll only
+// reads the files which have changed and the cost of re-hashing is mostly that of listing the
+// tree. An error from some poll, such as a file vanishing part way through, is passed to
+// onChange in place of a hash and the watch carries on; the next successful hash is always
+// reported. When ctx is done, Watch returns ctx.Err().
+//
+// If opts.Cache is set, it is used in place of the in-memory cache, and pruned after every
+// poll. Without one, only systems with inode numbers avoid reading every file on every poll.
+func Watch(ctx context.Context, path string, opts Options, interval time.Duration, onChange func(hash []byte, err error)) error {
+	return WatchPolls(ctx, path, opts, interval, func(hash []byte, changed bool, err error) {
+		if changed || err != nil {
+			onChange(hash, err)
+		}
+	})
+}
+
+// WatchPolls is like Watch, but calls onPoll after every poll rather than only when the hash
+// changes, saying whether it did, for callers which keep track of each one, such as its Stats.
+// A successful poll following an error always counts as a change.
+func WatchPolls(ctx context.Context, path string, opts Options, interval time.Duration, onPoll func(hash []byte, changed bool, err error)) error {
+	if opts.Cache == nil {
+		opts.Cache = &HashCache{entries: make(map[cacheKey]*cacheEntry)}
+	}
+
+	var last []byte
+	var failed bool
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hash, err := HashDirWithOptionsContext(ctx, path, opts)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			onPoll(nil, false, err)
+			failed = true
+		case failed || last == nil || !bytes.Equal(hash, last):
+			onPoll(hash, true, nil)
+			last, failed = hash, false
+			opts.Cache.Prune()
+		default:
+			opts.Cache.Prune()
+			onPoll(hash, false, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package dirhash
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// getCapability returns the raw "security.capability" extended attribute of the file at path,
+// or nil if it doesn't have one.
+func getCapability(path string) ([]byte, error) {
+	return getXattr(path, "security.capability")
+}
+
+// getXattr returns the value of the named extended attribute of the file at path, or nil if it
+// doesn't have one.
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		// Ask how big the attribute is, then try to read it into a buffer that size
+		size, err := syscall.Getxattr(path, name, nil)
+		if err == syscall.ENODATA || err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+		}
+
+		buf := make([]byte, size)
+		size, err = syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			continue // The attribute grew in between, so try again
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+		}
+		return buf[:size], nil
+	}
+}
+
+// listXattrs returns the names and values of all the extended attributes of the file at path
+// which can be read, sorted by name.
+func listXattrs(path string) ([]xattr, error) {
+	// List the names first, retrying like getCapability if the list grows in between
+	var names []byte
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+		}
+		names = make([]byte, size)
+		size, err = syscall.Listxattr(path, names)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+		}
+		names = names[:size]
+		break
+	}
+
+	var xattrs []xattr
+	for _, name := range strings.Split(string(names), "\x00") {
+		if name == "" {
+			continue
+		}
+		value, err := getXattr(path, name)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			xattrs = append(xattrs, xattr{name, value})
+		}
+	}
+	sort.Slice(xattrs, func(i, j int) bool { return xattrs[i].name < xattrs[j].name })
+	return xattrs, nil
+}
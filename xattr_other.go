@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dirhash
+
+// getCapability always reports no capabilities, since only Linux has them.
+func getCapability(path string) ([]byte, error) {
+	return nil, nil
+}
+
+// listXattrs always reports no extended attributes, since they're only read on Linux.
+func listXattrs(path string) ([]xattr, error) {
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package dirhash
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// HashZip hashes the tree contained in the zip archive at path, giving exactly the same hash as
+// HashDir would for the directory it unpacks to, without unpacking it. Directories which the
+// archive only implies, by the names of the files inside them, count just the same as those
+// with entries of their own.
+//
+// Names are taken exactly as the archive gives them, so a backslash is part of a name rather
+// than a separator. As for HashTar, symbolic links are hashed as the files they point to,
+// which must be found elsewhere in the archive, and entries which appear more than once
+// replace one another in turn.
+func HashZip(path string) ([]byte, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	return hashZip(&archive.Reader)
+}
+
+// HashZipReader is like HashZip, reading a zip archive of the given size from r.
+func HashZipReader(r io.ReaderAt, size int64) ([]byte, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return hashZip(archive)
+}
+
+func hashZip(archive *zip.Reader) ([]byte, error) {
+	w := newWalker(&Options{})
+	tree := newArchiveTree()
+	for _, f := range archive.File {
+		if err := w.addZipEntry(tree, f); err != nil {
+			return nil, err
+		}
+	}
+	return w.hashArchiveDir(tree.root, tree.root, nil)
+}
+
+// maxZipLink is the longest destination read from a symbolic link in a zip archive, which
+// keeps its contents as those of a file.
+const maxZipLink = 4096
+
+// addZipEntry adds the entry f of a zip archive to tree, hashing its contents if it is a file.
+func (w *walker) addZipEntry(tree *archiveTree, f *zip.File) error {
+	parts, err := archivePath(f.Name)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return nil // The root itself
+	}
+	dir, err := archiveDir(tree, parts[:len(parts)-1], f.Name)
+	if err != nil {
+		return err
+	}
+	name := parts[len(parts)-1]
+
+	mode := f.Mode()
+	switch {
+	case mode.IsDir():
+		tree.addDir(dir, name)
+		return nil
+	case mode.Type() != 0 && mode.Type() != fs.ModeSymlink:
+		return fmt.Errorf("%s: unsupported archive entry type %v", f.Name, mode.Type())
+	}
+
+	contents, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	if mode.IsRegular() {
+		return w.addArchiveFile(tree, dir, name, contents)
+	}
+	target, err := io.ReadAll(io.LimitReader(contents, maxZipLink+1))
+	if err != nil {
+		return err
+	}
+	if len(target) == 0 || len(target) > maxZipLink {
+		return fmt.Errorf("%s: invalid symbolic link", f.Name)
+	}
+	dir.children[name] = &archiveNode{link: string(target), layer: tree.layer}
+	return nil
+}
@@ -0,0 +1,84 @@
+package dirhash
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestHashZip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("names with backslashes can't be made on Windows")
+	}
+	files := map[string]string{
+		"back\\slash": "a",
+		"a/b/c.txt":   "implied directories",
+		"empty/":      "",
+		"top.txt":     "top",
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"top.txt", "empty/", "a/b/c.txt", "back\\slash"} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(files[name]))
+	}
+	header := &zip.FileHeader{Name: "link"}
+	header.SetMode(fs.ModeSymlink | 0777)
+	f, err := zw.CreateHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("a/b/c.txt"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same tree, as the archive unpacks to
+	root := makeTree(t, files)
+	if err := os.Symlink("a/b/c.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	want, err := HashDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := HashZipReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("HashZipReader gave %X, want %X", got, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.zip")
+	if err := os.WriteFile(path, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = HashZip(path); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("HashZip gave %X, want %X", got, want)
+	}
+}
+
+func TestHashZipOutsideRoot(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	if _, err := zw.Create("../escape"); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	if _, err := HashZipReader(bytes.NewReader(archive.Bytes()), int64(archive.Len())); err == nil {
+		t.Error("hashing an entry outside the root succeeded")
+	}
+}